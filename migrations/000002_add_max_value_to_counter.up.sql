@@ -1,2 +0,0 @@
--- Add max_value column which is int default 0 to counter table
-ALTER TABLE counter ADD COLUMN max_value Int DEFAULT 0;
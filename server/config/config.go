@@ -0,0 +1,378 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	// Embedded so that calendar_timezone resolves on images without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultEnvFile is read when it exists, it is the .env file at the root of the repository
+// when running from the server directory.
+const defaultEnvFile = "../.env"
+
+const redacted = "<redacted>"
+
+// ErrInvalidFlags is returned by Load when the command line cannot be parsed. The flag
+// package has already reported the error by then.
+var ErrInvalidFlags = errors.New("invalid flags")
+
+// Config is the configuration of the server and its subcommands. Every setting has a key, e.g.
+// db_port, used as is in the config file, upper-cased as environment variable, DB_PORT, and
+// dashed as command-line flag, --db-port.
+type Config struct {
+	Host string
+	Port int
+
+	DBDriver   string
+	DBHost     string
+	DBPort     int
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DBPath     string
+
+	UpdateIntervalInHours            int
+	CounterIncrementFrequencyInHours int
+	UndoWindowInMinutes              int
+	IdempotencyKeyTTLInHours         int
+	IdempotencyKeyInFlightInSeconds  int
+	CalendarTimezone                 string
+	UIRootURL                        string
+	// PublicReads serves the read endpoints without API key, mutating ones always require one.
+	PublicReads bool
+
+	LogFormat string
+	LogLevel  string
+
+	HTTPReadTimeoutInSeconds  int
+	HTTPWriteTimeoutInSeconds int
+	HTTPIdleTimeoutInSeconds  int
+	ShutdownTimeoutInSeconds  int
+
+	// PrintConfig asks for the effective configuration to be printed instead of running.
+	PrintConfig bool
+
+	settings []setting
+	// sources tells where the value of every key comes from
+	sources map[string]string
+}
+
+// setting binds a key to its field.
+type setting struct {
+	key    string
+	secret bool
+	value  func() string
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
+
+func envName(key string) string {
+	return strings.ToUpper(key)
+}
+
+// defineFlags registers a flag per setting, with its default value, on fs.
+func (c *Config) defineFlags(fs *flag.FlagSet) {
+	str := func(p *string, key string, value string, usage string, secret bool) {
+		fs.StringVar(p, flagName(key), value, usage)
+		c.settings = append(c.settings, setting{key: key, secret: secret, value: func() string { return *p }})
+	}
+	num := func(p *int, key string, value int, usage string) {
+		fs.IntVar(p, flagName(key), value, usage)
+		c.settings = append(c.settings, setting{key: key, value: func() string { return strconv.Itoa(*p) }})
+	}
+	boolean := func(p *bool, key string, value bool, usage string) {
+		fs.BoolVar(p, flagName(key), value, usage)
+		c.settings = append(c.settings, setting{key: key, value: func() string { return strconv.FormatBool(*p) }})
+	}
+
+	str(&c.Host, "host", "", "host name the server is reached at, only logged", false)
+	num(&c.Port, "port", 8080, "port to listen on")
+
+	str(&c.DBDriver, "db_driver", "pgx", "database to store counters in: pgx for Postgres, sqlite or memory", false)
+	str(&c.DBHost, "db_host", "", "Postgres host", false)
+	num(&c.DBPort, "db_port", 5432, "Postgres port")
+	str(&c.DBUser, "db_user", "", "Postgres user", false)
+	str(&c.DBPassword, "db_password", "", "Postgres password", true)
+	str(&c.DBName, "db_name", "", "Postgres database name", false)
+	str(&c.DBPath, "db_path", "", "SQLite database file", false)
+
+	num(&c.UpdateIntervalInHours, "update_interval_in_hours", 24, "hours between two increments of a counter")
+	num(&c.CounterIncrementFrequencyInHours, "counter_increment_frequency_in_hours", 1, "hours between two ticks of the background task")
+	num(&c.UndoWindowInMinutes, "undo_window_in_minutes", 10, "minutes during which the last transition can be undone")
+	num(&c.IdempotencyKeyTTLInHours, "idempotency_key_ttl_in_hours", 24, "hours an Idempotency-Key is remembered")
+	num(&c.IdempotencyKeyInFlightInSeconds, "idempotency_key_in_flight_in_seconds", 120, "seconds an Idempotency-Key stays reserved for a request that did not complete")
+	str(&c.CalendarTimezone, "calendar_timezone", "UTC", "IANA time zone of the days of /calendar.ics", false)
+	str(&c.UIRootURL, "ui_root_url", "", "origin of the UI allowed by CORS, next to http://localhost:3000", false)
+	boolean(&c.PublicReads, "public_reads", true, "serve the read endpoints without API key")
+
+	str(&c.LogFormat, "log_format", "text", "log format: text or json", false)
+	str(&c.LogLevel, "log_level", "info", "lowest level logged: debug, info, warn or error", false)
+
+	num(&c.HTTPReadTimeoutInSeconds, "http_read_timeout_in_seconds", 10, "seconds to read a request")
+	num(&c.HTTPWriteTimeoutInSeconds, "http_write_timeout_in_seconds", 60, "seconds to write a response, /export included")
+	num(&c.HTTPIdleTimeoutInSeconds, "http_idle_timeout_in_seconds", 120, "seconds an idle connection is kept open")
+	num(&c.ShutdownTimeoutInSeconds, "shutdown_timeout_in_seconds", 30, "seconds to drain the requests in flight on shutdown")
+}
+
+func (c *Config) isKey(key string) bool {
+	_, ok := c.sources[key]
+	return ok
+}
+
+// set parses value into the setting of key, source tells where value comes from.
+func (c *Config) set(fs *flag.FlagSet, key string, value string, source string) error {
+	err := fs.Lookup(flagName(key)).Value.Set(value)
+	if err != nil {
+		return fmt.Errorf("❌ Invalid %s from %s: %q is not a valid value", key, source, value)
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// Load registers the settings as flags of fs, parses args and fills in the settings that were
+// not given as flags from, by decreasing precedence: the environment, the .env file, the config
+// file and the defaults. The config file is given by --config or CONFIG_FILE, the .env file by
+// --env-file. The configuration is not validated.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := &Config{sources: map[string]string{}}
+	c.defineFlags(fs)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML file to read the configuration from")
+	envFile := fs.String("env-file", defaultEnvFile, ".env file to read environment variables from")
+	fs.BoolVar(&c.PrintConfig, "print-config", false, "print the effective configuration, secrets redacted, and exit")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFlags, err)
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for _, s := range c.settings {
+		c.sources[s.key] = "default"
+	}
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if !c.isKey(key) {
+				return nil, fmt.Errorf("❌ Unknown key %s in %s", key, *configFile)
+			}
+			if explicit[flagName(key)] {
+				continue
+			}
+			err = c.set(fs, key, value, *configFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dotenv, err := godotenv.Read(*envFile)
+	if err != nil && (explicit["env-file"] || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("❌ Error reading %s.\n %s", *envFile, err)
+	}
+	for _, s := range c.settings {
+		if explicit[flagName(s.key)] {
+			c.sources[s.key] = "flag --" + flagName(s.key)
+			continue
+		}
+		var err error
+		name := envName(s.key)
+		if value := os.Getenv(name); value != "" {
+			err = c.set(fs, s.key, value, "env "+name)
+		} else if value := dotenv[name]; value != "" {
+			err = c.set(fs, s.key, value, *envFile+" "+name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readConfigFile reads the top-level keys of a YAML or TOML file, told apart by extension.
+func readConfigFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error reading %s.\n %s", path, err)
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		return nil, fmt.Errorf("❌ Unknown format of %s, expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error parsing %s.\n %s", path, err)
+	}
+
+	values := map[string]string{}
+	for key, value := range raw {
+		switch value.(type) {
+		case string, bool, int, int64, uint64, float64:
+			values[key] = fmt.Sprint(value)
+		default:
+			return nil, fmt.Errorf("❌ Invalid %s in %s: expected a single value", key, path)
+		}
+	}
+	return values, nil
+}
+
+// Validate checks every setting and reports all the invalid ones, naming their key and where
+// their value comes from.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("❌ Invalid %s from %s: %s", key, c.sources[key], fmt.Sprintf(format, args...)))
+	}
+	validPort := func(key string, port int) {
+		if port < 1 || port > 65535 {
+			invalid(key, "%d is not a port between 1 and 65535", port)
+		}
+	}
+	atLeast := func(key string, value int, minimum int) {
+		if value < minimum {
+			invalid(key, "%d is less than %d", value, minimum)
+		}
+	}
+	required := func(key string, value string) {
+		if value == "" {
+			invalid(key, "required when db_driver is %s", c.DBDriver)
+		}
+	}
+
+	validPort("port", c.Port)
+
+	switch c.DBDriver {
+	case "pgx":
+		required("db_host", c.DBHost)
+		validPort("db_port", c.DBPort)
+		required("db_user", c.DBUser)
+		required("db_password", c.DBPassword)
+		required("db_name", c.DBName)
+	case "sqlite":
+		required("db_path", c.DBPath)
+	case "memory":
+	default:
+		invalid("db_driver", "%q is not one of pgx, sqlite or memory", c.DBDriver)
+	}
+
+	atLeast("update_interval_in_hours", c.UpdateIntervalInHours, 0)
+	atLeast("counter_increment_frequency_in_hours", c.CounterIncrementFrequencyInHours, 1)
+	atLeast("undo_window_in_minutes", c.UndoWindowInMinutes, 0)
+	atLeast("idempotency_key_ttl_in_hours", c.IdempotencyKeyTTLInHours, 1)
+	atLeast("idempotency_key_in_flight_in_seconds", c.IdempotencyKeyInFlightInSeconds, 1)
+	if _, err := time.LoadLocation(c.CalendarTimezone); err != nil {
+		invalid("calendar_timezone", "%q is not a known time zone", c.CalendarTimezone)
+	}
+	if c.UIRootURL != "" {
+		u, err := url.Parse(c.UIRootURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			invalid("ui_root_url", "%q is not an absolute URL", c.UIRootURL)
+		}
+	}
+
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		invalid("log_format", "%q is not one of text or json", c.LogFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		invalid("log_level", "%q is not one of debug, info, warn or error", c.LogLevel)
+	}
+
+	atLeast("http_read_timeout_in_seconds", c.HTTPReadTimeoutInSeconds, 1)
+	atLeast("http_write_timeout_in_seconds", c.HTTPWriteTimeoutInSeconds, 1)
+	atLeast("http_idle_timeout_in_seconds", c.HTTPIdleTimeoutInSeconds, 1)
+	atLeast("shutdown_timeout_in_seconds", c.ShutdownTimeoutInSeconds, 1)
+
+	return errors.Join(errs...)
+}
+
+// Print writes the effective configuration as YAML, usable as config file, with where every
+// value comes from as comment. Secrets that are set are redacted.
+func (c *Config) Print(w io.Writer) error {
+	for _, s := range c.settings {
+		value := s.value()
+		if s.secret && value != "" {
+			value = redacted
+		}
+		if _, err := strconv.Atoi(value); err != nil && value != "true" && value != "false" {
+			value = strconv.Quote(value)
+		}
+		_, err := fmt.Fprintf(w, "%s: %s # %s\n", s.key, value, c.sources[s.key])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) UpdateInterval() time.Duration {
+	return time.Duration(c.UpdateIntervalInHours) * time.Hour
+}
+
+func (c *Config) CounterIncrementFrequency() time.Duration {
+	return time.Duration(c.CounterIncrementFrequencyInHours) * time.Hour
+}
+
+func (c *Config) UndoWindow() time.Duration {
+	return time.Duration(c.UndoWindowInMinutes) * time.Minute
+}
+
+func (c *Config) IdempotencyKeyTTL() time.Duration {
+	return time.Duration(c.IdempotencyKeyTTLInHours) * time.Hour
+}
+
+func (c *Config) IdempotencyKeyInFlight() time.Duration {
+	return time.Duration(c.IdempotencyKeyInFlightInSeconds) * time.Second
+}
+
+// CalendarLocation is the time zone of calendar_timezone, UTC if it is unknown.
+func (c *Config) CalendarLocation() *time.Location {
+	location, err := time.LoadLocation(c.CalendarTimezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+func (c *Config) HTTPReadTimeout() time.Duration {
+	return time.Duration(c.HTTPReadTimeoutInSeconds) * time.Second
+}
+
+func (c *Config) HTTPWriteTimeout() time.Duration {
+	return time.Duration(c.HTTPWriteTimeoutInSeconds) * time.Second
+}
+
+func (c *Config) HTTPIdleTimeout() time.Duration {
+	return time.Duration(c.HTTPIdleTimeoutInSeconds) * time.Second
+}
+
+func (c *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(c.ShutdownTimeoutInSeconds) * time.Second
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func load(t *testing.T, args ...string) *Config {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg, err := Load(fs, append([]string{"--env-file", writeFile(t, ".env", "")}, args...))
+	if err != nil {
+		t.Fatalf("failed to load config: %s", err)
+	}
+	return cfg
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %s", name, err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PORT", "")
+	t.Setenv("DB_DRIVER", "")
+	t.Setenv("LOG_LEVEL", "warn")
+	file := writeFile(t, "config.yaml", "port: 9000\ndb_driver: sqlite\nlog_level: debug\n")
+
+	cfg := load(t, "--config", file, "--port", "9100")
+	if cfg.Port != 9100 {
+		t.Errorf("expected the flag to win, got port %d", cfg.Port)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("expected the environment to win over the file, got log_level %s", cfg.LogLevel)
+	}
+	if cfg.DBDriver != "sqlite" {
+		t.Errorf("expected the file to win over the default, got db_driver %s", cfg.DBDriver)
+	}
+	if cfg.UpdateIntervalInHours != 24 {
+		t.Errorf("expected the default update_interval_in_hours, got %d", cfg.UpdateIntervalInHours)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("PORT", "")
+	file := writeFile(t, "config.toml", "port = 9000\n")
+
+	cfg := load(t, "--config", file)
+	if cfg.Port != 9000 {
+		t.Errorf("expected port 9000, got %d", cfg.Port)
+	}
+}
+
+func TestLoadRejectsUnknownKey(t *testing.T) {
+	file := writeFile(t, "config.yaml", "prot: 9000\n")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"--config", file})
+	if err == nil || !strings.Contains(err.Error(), "prot") {
+		t.Errorf("expected an error naming prot, got %v", err)
+	}
+}
+
+func TestValidateNamesKey(t *testing.T) {
+	t.Setenv("DB_DRIVER", "")
+	t.Setenv("DB_PATH", "")
+	t.Setenv("PORT", "")
+	t.Setenv("UNDO_WINDOW_IN_MINUTES", "-1")
+
+	cfg := load(t, "--db-driver", "sqlite", "--port", "70000")
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an invalid config")
+	}
+	for _, expected := range []string{
+		"port from flag --port",
+		"db_path from default",
+		"undo_window_in_minutes from env UNDO_WINDOW_IN_MINUTES",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %q", expected, err)
+		}
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "hunter2")
+
+	var output strings.Builder
+	if err := load(t).Print(&output); err != nil {
+		t.Fatalf("failed to print config: %s", err)
+	}
+	if strings.Contains(output.String(), "hunter2") {
+		t.Errorf("expected the password to be redacted, got %s", output.String())
+	}
+	if !strings.Contains(output.String(), `db_password: "<redacted>" # env DB_PASSWORD`) {
+		t.Errorf("expected a redacted db_password, got %s", output.String())
+	}
+}
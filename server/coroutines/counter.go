@@ -2,54 +2,93 @@ package coroutines
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"server/db"
-	"server/utils"
+	"server/metrics"
+	"sync"
 	"time"
 )
 
-var cancelFunc context.CancelFunc
-var taskRunning bool
+// BackgroundTask periodically increments the counter of the given store.
+type BackgroundTask struct {
+	store       db.CounterStore
+	frequency   time.Duration
+	mu          sync.Mutex
+	cancelFunc  context.CancelFunc
+	taskRunning bool
+	// done is closed when the running task returns
+	done chan struct{}
+}
 
-func runBackgroundTask(ctx context.Context) {
-	incrementFrequencyInHours, ok := utils.GetEnvInt("COUNTER_INCREMENT_FREQUENCY_IN_HOURS")
-	if ok != nil {
-		log.Println("❌ Error getting COUNTER_INCREMENT_FREQUENCY_IN_HOURS")
-		return
-	}
-	ticker := time.NewTicker(time.Duration(incrementFrequencyInHours) * time.Hour)
+// NewBackgroundTask returns a stopped task ticking every frequency once run.
+func NewBackgroundTask(store db.CounterStore, frequency time.Duration) *BackgroundTask {
+	return &BackgroundTask{store: store, frequency: frequency}
+}
+
+func (t *BackgroundTask) runBackgroundTask(ctx context.Context) {
+	ticker := time.NewTicker(t.frequency)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			isUpdated := db.UpdateCounter()
-			if !isUpdated {
-				log.Printf("❌ Counter not incremented. Conditions not met.")
+			// A tick in progress completes even when the task is stopped meanwhile
+			isUpdated, err := t.store.UpdateCounter(context.WithoutCancel(ctx))
+			metrics.RecordTick(isUpdated, err)
+			if err != nil {
+				slog.ErrorContext(ctx, "❌ Error updating counter.", "error", err)
+			} else if !isUpdated {
+				slog.InfoContext(ctx, "❌ Counter not incremented. Conditions not met.")
 			}
 		case <-ctx.Done():
-			log.Println("🛑 Background task stopped")
+			slog.InfoContext(ctx, "🛑 Background task stopped")
 			return
 		}
 	}
 }
 
-func RunBackgroundTask() {
-	if taskRunning {
-		log.Println("⚠️ Background task is already running")
+func (t *BackgroundTask) RunBackgroundTask() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.taskRunning {
+		slog.Warn("⚠️ Background task is already running")
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	cancelFunc = cancel
-	taskRunning = true
+	done := make(chan struct{})
+	t.cancelFunc = cancel
+	t.taskRunning = true
+	t.done = done
 	go func() {
-		runBackgroundTask(ctx)
-		taskRunning = false
+		defer close(done)
+		t.runBackgroundTask(ctx)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.done == done {
+			t.taskRunning = false
+		}
 	}()
 }
 
-func StopBackgroundTask() {
-	if cancelFunc != nil {
-		cancelFunc()
+func (t *BackgroundTask) StopBackgroundTask() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cancelFunc != nil {
+		t.cancelFunc()
+	}
+}
+
+// Shutdown stops the background task and waits for a tick in progress to finish.
+func (t *BackgroundTask) Shutdown() {
+	t.StopBackgroundTask()
+
+	t.mu.Lock()
+	done := t.done
+	t.mu.Unlock()
+	if done != nil {
+		<-done
 	}
 }
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"server/utils"
+	"time"
+)
+
+// ErrUnknownAPIKey is returned when authenticating with a key that was never created or was
+// revoked.
+var ErrUnknownAPIKey = errors.New("unknown or revoked API key")
+
+// ErrAPIKeyNotFound is returned when revoking a key id that does not exist.
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
+// Role is what an API key is allowed to do. Every role is allowed what the previous ones are.
+type Role string
+
+const (
+	// RoleViewer reads the counters, the history and the metrics.
+	RoleViewer Role = "viewer"
+	// RoleRecorder also records ohno and fine events and undoes them.
+	RoleRecorder Role = "recorder"
+	// RoleAdmin also changes the counters, the background task and the history.
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleRecorder: 2,
+	RoleAdmin:    3,
+}
+
+// ErrInvalidRole is returned for a role that is none of viewer, recorder or admin.
+var ErrInvalidRole = errors.New("invalid role, expected viewer, recorder or admin")
+
+// Valid reports whether r is one of the known roles.
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether r is allowed what required is.
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+const (
+	apiKeyPrefix = "ohno_"
+	// apiKeyVisibleLength is the length of the start of a key kept in clear to tell keys apart.
+	apiKeyVisibleLength = 12
+)
+
+// APIKey describes an API key. The key itself is only known when it is generated, the store
+// keeps its hash.
+type APIKey struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	Role       Role
+	CreatedAt  string
+	LastUsedAt *string
+	RevokedAt  *string
+}
+
+// GenerateAPIKey returns a new random API key.
+func GenerateAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("❌ Error generating API key.\n %s", err)
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 of key. Keys are random enough for a plain hash to
+// be safe, and a plain hash lets the key be looked up by hash.
+func hashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func apiKeyVisiblePrefix(key string) string {
+	return key[:min(len(key), apiKeyVisibleLength)]
+}
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var apiKey APIKey
+	var lastUsedAt, revokedAt sql.NullString
+	err := row.Scan(&apiKey.ID, &apiKey.Name, &apiKey.Prefix, &apiKey.Role, &apiKey.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	apiKey.LastUsedAt = nullableString(lastUsedAt)
+	apiKey.RevokedAt = nullableString(revokedAt)
+	return apiKey, nil
+}
+
+const apiKeyColumns = "id, name, prefix, role, created_at, last_used_at, revoked_at"
+
+// CreateAPIKey stores the hash of key under name, granting it role.
+func (s *SQLStore) CreateAPIKey(ctx context.Context, name string, role Role, key string) (APIKey, error) {
+	if !role.Valid() {
+		return APIKey{}, fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	tableName := utils.TableInstance.APIKeys
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (name, prefix, role, key_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING %s
+	`, tableName, apiKeyColumns)
+	row := s.db.QueryRowContext(ctx, insertQuery, name, apiKeyVisiblePrefix(key), string(role), hashAPIKey(key), formatSQLTimestamp(time.Now()))
+	apiKey, err := scanAPIKey(row)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+	}
+	return apiKey, nil
+}
+
+// ListAPIKeys returns every API key, revoked ones included, oldest first.
+func (s *SQLStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	tableName := utils.TableInstance.APIKeys
+	selectQuery := fmt.Sprintf(`SELECT %s FROM %s ORDER BY id`, apiKeyColumns, tableName)
+	rows, err := s.db.QueryContext(ctx, selectQuery)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	defer rows.Close()
+
+	var apiKeys []APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error scanning row.\n %s", err)
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("❌ Row iteration error.\n %s", err)
+	}
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes the key with the given id for good. Revoking a revoked key keeps the
+// time of the first revocation.
+func (s *SQLStore) RevokeAPIKey(ctx context.Context, id int64) error {
+	tableName := utils.TableInstance.APIKeys
+	updateQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET revoked_at = COALESCE(revoked_at, $1)
+		WHERE id = $2
+	`, tableName)
+	result, err := s.db.ExecContext(ctx, updateQuery, formatSQLTimestamp(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("%w: %d", ErrAPIKeyNotFound, id)
+	}
+	return nil
+}
+
+// apiKeyUseResolution is how stale last_used_at may get. A key used again within it is not
+// written to, so that every authenticated request does not turn into a write.
+const apiKeyUseResolution = time.Minute
+
+// apiKeyUseIsStale reports whether the use of apiKey at now should be written to last_used_at.
+func apiKeyUseIsStale(apiKey APIKey, now time.Time) (bool, error) {
+	if apiKey.LastUsedAt == nil {
+		return true, nil
+	}
+	lastUsedAt, err := parseTimestamp(*apiKey.LastUsedAt)
+	if err != nil {
+		return false, fmt.Errorf("❌ Error parsing last_used_at timestamp.\n %s", err)
+	}
+	return now.Sub(lastUsedAt) >= apiKeyUseResolution, nil
+}
+
+// AuthenticateAPIKey returns the API key matching key and records its use, at most once per
+// apiKeyUseResolution. It returns ErrUnknownAPIKey if there is none or if it was revoked.
+func (s *SQLStore) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	tableName := utils.TableInstance.APIKeys
+	selectQuery := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`, apiKeyColumns, tableName)
+	apiKey, err := scanAPIKey(s.db.QueryRowContext(ctx, selectQuery, hashAPIKey(key)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, ErrUnknownAPIKey
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+
+	now := time.Now()
+	isStale, err := apiKeyUseIsStale(apiKey, now)
+	if err != nil || !isStale {
+		return apiKey, err
+	}
+	lastUsedAt := formatSQLTimestamp(now)
+	updateQuery := fmt.Sprintf(`UPDATE %s SET last_used_at = $1 WHERE id = $2`, tableName)
+	_, err = s.db.ExecContext(ctx, updateQuery, lastUsedAt, apiKey.ID)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	apiKey.LastUsedAt = &lastUsedAt
+	return apiKey, nil
+}
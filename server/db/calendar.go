@@ -0,0 +1,136 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	calendarDateLayout     = "20060102"
+	calendarDateTimeLayout = "20060102T150405Z"
+	// calendarLineLength is the maximum length of a content line in octets, without the CRLF.
+	calendarLineLength = 75
+)
+
+// BuildCalendar renders the ill periods of the timeline as an RFC 5545 calendar with one all-day
+// event per period, in days of the given location. A period gets its UID from its counter_id,
+// the open one from the counter_id the fine transition ending it will give it, so that
+// subscribed clients update events instead of duplicating them.
+func BuildCalendar(ctx context.Context, store CounterStore, now time.Time, location *time.Location) (string, error) {
+	timeline, err := BuildTimeline(ctx, store, now)
+	if err != nil {
+		return "", err
+	}
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		return "", err
+	}
+	illSince, err := parseNullableTimestamp(nullableString(healthState.ChangedAt))
+	if err != nil {
+		return "", fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+	}
+
+	var lines []string
+	lines = append(lines,
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//oh-no//calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:oh-no",
+	)
+	for _, period := range timeline {
+		if period.Kind != Ill {
+			continue
+		}
+		uid := period.CounterID
+		if period.EndedAt == nil {
+			uid = periodCounterID(transitions[Healthy].historicalTable, illSince)
+		}
+		event, err := calendarEvent(period, uid, now, location)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, event...)
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var builder strings.Builder
+	for _, line := range lines {
+		builder.WriteString(foldCalendarLine(line))
+		builder.WriteString("\r\n")
+	}
+	return builder.String(), nil
+}
+
+func calendarEvent(period TimelinePeriod, uid string, now time.Time, location *time.Location) ([]string, error) {
+	endedAt := now
+	if period.EndedAt != nil {
+		parsed, err := parseTimestamp(*period.EndedAt)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error parsing ended_at timestamp.\n %s", err)
+		}
+		endedAt = parsed
+	}
+	// Periods with an unknown start only cover the day they ended
+	startedAt := endedAt
+	if period.StartedAt != nil {
+		parsed, err := parseTimestamp(*period.StartedAt)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error parsing started_at timestamp.\n %s", err)
+		}
+		startedAt = parsed
+	}
+
+	// DTEND is exclusive, the day the period ended is included unless it ended at midnight
+	startDate := calendarDay(startedAt, location)
+	endDate := calendarDay(endedAt, location)
+	if !endedAt.In(location).Equal(endDate) || !endDate.After(startDate) {
+		endDate = endDate.AddDate(0, 0, 1)
+	}
+
+	description := fmt.Sprintf("Ill, the ohno counter reached %d", period.Value)
+	if period.EndedAt == nil {
+		description = fmt.Sprintf("Still ill, the ohno counter is at %d", period.Value)
+	}
+	return []string{
+		"BEGIN:VEVENT",
+		"UID:" + uid + "@oh-no",
+		"DTSTAMP:" + endedAt.UTC().Format(calendarDateTimeLayout),
+		"DTSTART;VALUE=DATE:" + startDate.Format(calendarDateLayout),
+		"DTEND;VALUE=DATE:" + endDate.Format(calendarDateLayout),
+		"SUMMARY:Ill",
+		"DESCRIPTION:" + escapeCalendarText(description),
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+	}, nil
+}
+
+// calendarDay returns midnight of the day t falls on in the given location.
+func calendarDay(t time.Time, location *time.Location) time.Time {
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+}
+
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(text)
+}
+
+// foldCalendarLine splits lines longer than calendarLineLength octets, continuation lines start
+// with a space. Multi-byte characters are not split.
+func foldCalendarLine(line string) string {
+	var builder strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > calendarLineLength {
+			builder.WriteString("\r\n ")
+			length = 1
+		}
+		builder.WriteRune(r)
+		length += size
+	}
+	return builder.String()
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarEvent(t *testing.T) {
+	now := time.Date(2024, 3, 21, 12, 0, 0, 0, time.UTC)
+	timestamp := func(at time.Time) *string {
+		return formatNullableTimestamp(&at)
+	}
+	for _, tc := range []struct {
+		name         string
+		period       TimelinePeriod
+		location     *time.Location
+		expectedDays string
+		description  string
+	}{
+		{
+			name:         "ended in the morning, the day is included",
+			period:       TimelinePeriod{StartedAt: timestamp(date(2024, 1, 10)), EndedAt: timestamp(date(2024, 1, 15).Add(8 * time.Hour)), Value: 2},
+			expectedDays: "20240110..20240116",
+			description:  "Ill, the ohno counter reached 2",
+		},
+		{
+			name:         "ended at midnight, the day is not",
+			period:       TimelinePeriod{StartedAt: timestamp(date(2024, 3, 1)), EndedAt: timestamp(date(2024, 3, 2)), Value: 1},
+			expectedDays: "20240301..20240302",
+			description:  "Ill, the ohno counter reached 1",
+		},
+		{
+			name:         "within a day",
+			period:       TimelinePeriod{StartedAt: timestamp(date(2024, 3, 1).Add(8 * time.Hour)), EndedAt: timestamp(date(2024, 3, 1).Add(10 * time.Hour)), Value: 1},
+			expectedDays: "20240301..20240302",
+			description:  "Ill, the ohno counter reached 1",
+		},
+		{
+			name:         "unknown start, only the day it ended",
+			period:       TimelinePeriod{EndedAt: timestamp(date(2024, 3, 2).Add(10 * time.Hour)), Value: 3},
+			expectedDays: "20240302..20240303",
+			description:  "Ill, the ohno counter reached 3",
+		},
+		{
+			name:         "open, until today",
+			period:       TimelinePeriod{StartedAt: timestamp(date(2024, 3, 20).Add(12 * time.Hour)), Value: 1},
+			expectedDays: "20240320..20240322",
+			description:  "Still ill, the ohno counter is at 1",
+		},
+		{
+			name:         "days of the time zone",
+			period:       TimelinePeriod{StartedAt: timestamp(date(2024, 1, 10)), EndedAt: timestamp(date(2024, 1, 15).Add(8 * time.Hour)), Value: 2},
+			location:     time.FixedZone("UTC-5", -5*60*60),
+			expectedDays: "20240109..20240116",
+			description:  "Ill, the ohno counter reached 2",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			location := tc.location
+			if location == nil {
+				location = time.UTC
+			}
+			tc.period.Kind = Ill
+			lines, err := calendarEvent(tc.period, "uid", now, location)
+			if err != nil {
+				t.Fatalf("failed to render event: %s", err)
+			}
+
+			fields := map[string]string{}
+			for _, line := range lines {
+				name, value, _ := strings.Cut(line, ":")
+				fields[name] = value
+			}
+			days := fields["DTSTART;VALUE=DATE"] + ".." + fields["DTEND;VALUE=DATE"]
+			if days != tc.expectedDays {
+				t.Errorf("expected the days %s, got %s", tc.expectedDays, days)
+			}
+			if fields["UID"] != "uid@oh-no" || fields["DESCRIPTION"] != escapeCalendarText(tc.description) {
+				t.Errorf("expected UID uid@oh-no and description %q, got %v", tc.description, lines)
+			}
+			if lines[0] != "BEGIN:VEVENT" || lines[len(lines)-1] != "END:VEVENT" {
+				t.Errorf("expected a VEVENT, got %v", lines)
+			}
+		})
+	}
+}
+
+func TestBuildCalendar(t *testing.T) {
+	illSince := date(2024, 3, 20).Add(12 * time.Hour)
+	store := timelineFixture{
+		periods: []fixturePeriod{
+			{kind: Healthy, endedAt: date(2024, 1, 10), value: 5},
+			{kind: Ill, endedAt: date(2024, 1, 15).Add(8 * time.Hour), value: 2},
+			{kind: Healthy, endedAt: date(2024, 3, 1), value: 10},
+			{kind: Ill, endedAt: date(2024, 3, 2), value: 1},
+			{kind: Healthy, endedAt: illSince, value: 18},
+		},
+		state:     Ill,
+		changedAt: illSince,
+		counter:   1,
+	}.store()
+
+	calendar, err := BuildCalendar(context.Background(), store, date(2024, 3, 21), time.UTC)
+	if err != nil {
+		t.Fatalf("failed to build calendar: %s", err)
+	}
+	if !strings.HasPrefix(calendar, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n") || !strings.HasSuffix(calendar, "END:VCALENDAR\r\n") {
+		t.Errorf("expected a calendar with CRLF line endings, got %q", calendar)
+	}
+
+	// Ill periods only, the open one identified by the counter_id it will get
+	expected := []string{
+		"UID:00000000-0000-0000-0000-000000000002@oh-no",
+		"UID:00000000-0000-0000-0000-000000000004@oh-no",
+		"UID:" + periodCounterID(transitions[Healthy].historicalTable, &illSince) + "@oh-no",
+	}
+	var uids []string
+	for _, line := range strings.Split(calendar, "\r\n") {
+		if strings.HasPrefix(line, "UID:") {
+			uids = append(uids, line)
+		}
+	}
+	if strings.Join(uids, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected events %v, got %v", expected, uids)
+	}
+}
+
+func TestFoldCalendarLine(t *testing.T) {
+	line := "DESCRIPTION:" + strings.Repeat("é", 40)
+	folded := foldCalendarLine(line)
+	for _, part := range strings.Split(folded, "\r\n") {
+		if len(part) > calendarLineLength {
+			t.Errorf("expected lines of at most %d octets, got %d", calendarLineLength, len(part))
+		}
+	}
+	if strings.ReplaceAll(folded, "\r\n ", "") != line {
+		t.Errorf("expected unfolding to give the line back, got %q", folded)
+	}
+}
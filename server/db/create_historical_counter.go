@@ -1,30 +1,66 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"log/slog"
+	"time"
 )
 
-func CreateHistoricalCounter(tableName string, lastValue int) error {
+func (s *SQLStore) CreateHistoricalCounter(ctx context.Context, tableName string, lastValue int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+
+	_, err = s.createHistoricalCounter(ctx, tx, tableName, uuid.New().String(), lastValue, nil, formatSQLTimestamp(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	return nil
+}
+
+// periodNamespace is the UUID namespace of the counter_ids derived by periodCounterID.
+var periodNamespace = uuid.MustParse("5b0c9a3e-6f1d-4c52-9e8a-2d7f4b1c0a69")
+
+// periodCounterID derives the counter_id of the historical row in tableName for the period
+// started at startedAt, so that the period can be identified by the same id while it is still
+// open. The first period, with an unknown start, gets its id from tableName alone.
+func periodCounterID(tableName string, startedAt *time.Time) string {
+	name := tableName
+	if startedAt != nil {
+		name += " " + startedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return uuid.NewSHA1(periodNamespace, []byte(name)).String()
+}
+
+// createHistoricalCounter inserts a historical row with counterId holding lastValue for the
+// period from startedAt to endedAt, created at endedAt, within the given transaction and returns
+// its counter_id. Non-positive values are skipped, there is no period
+// worth remembering, and an empty counter_id is returned.
+func (s *SQLStore) createHistoricalCounter(ctx context.Context, tx *sql.Tx, tableName string, counterId string, lastValue int, startedAt *string, endedAt string) (string, error) {
 	if lastValue <= 0 {
-		message := fmt.Sprintf("❌ Error creating new historical counter. Value must be greater than 0. Received: %d", lastValue)
-		log.Printf(message)
-		return nil
+		slog.WarnContext(ctx, "❌ Error creating new historical counter. Value must be greater than 0.", "table", tableName, "value", lastValue)
+		return "", nil
 	}
-	newCounterId := uuid.New().String()
 
 	rawInsertQuery := `
-		INSERT INTO %s (counter_id, value)
-		VALUES ('%s', %d);
+		INSERT INTO %s (counter_id, value, created_at, updated_at, started_at, ended_at)
+		VALUES ('%s', %d, $1, $1, $2, $1);
 	`
-	insertQuery := fmt.Sprintf(rawInsertQuery, tableName, newCounterId, lastValue)
+	insertQuery := fmt.Sprintf(rawInsertQuery, tableName, counterId, lastValue)
 
-	_, err := db.Exec(insertQuery)
+	_, err := tx.Exec(insertQuery, endedAt, startedAt)
 	if err != nil {
-		message := fmt.Sprintf("❌ Error inserting new %s row.\n %s", tableName, err)
-		log.Fatalf(message)
-		return fmt.Errorf(message)
+		return "", fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
 	}
-	return nil
+	return counterId, nil
 }
@@ -3,11 +3,12 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"server/db/models"
 	"server/utils"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,30 +18,28 @@ import (
 )
 
 var postgresContainer testcontainers.Container
+var db *sql.DB
+var store *SQLStore
 
 /*
 Setup
 */
 func TestMain(m *testing.M) {
-	// Setup before running tests
+	// Setup before running tests. Without Docker only the in-memory store is tested.
 	if err := setup(); err != nil {
-		log.Fatalf("Could not set up test container: %v", err)
+		log.Printf("Could not set up test container, skipping Postgres tests: %v", err)
+		postgresContainer = nil
+		store = nil
 	}
 
-	// Ensure db is properly initialized before running tests
-	if db == nil {
-		log.Fatalf("Database connection is not initialized")
-	}
-
-	// Set relevant environment variables
-	os.Setenv("UPDATE_INTERVAL_IN_HOURS", "24")
-
 	// Run tests
 	code := m.Run()
 
 	// Teardown after tests
-	if err := teardown(); err != nil {
-		log.Fatalf("Could not tear down test container: %v", err)
+	if store != nil {
+		if err := teardown(); err != nil {
+			log.Fatalf("Could not tear down test container: %v", err)
+		}
 	}
 
 	os.Exit(code)
@@ -85,33 +84,15 @@ func setup() error {
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	store = NewPostgresStore(db)
 
 	/*
 		Prepare tables for testing and create triggers
 	*/
-	models.CreateCounterTableIfNotExists(db, utils.TableInstance.Counter)
-	models.CreateOrReplaceTrigger(db, utils.TableInstance.Counter)
-	models.CreateCounterTableIfNotExists(db, utils.TableInstance.OhnoCounter)
-	models.CreateOrReplaceTrigger(db, utils.TableInstance.OhnoCounter)
-	createHistoricalCounterForTest(db, utils.TableInstance.HistoricalCounter)
-	createHistoricalCounterForTest(db, utils.TableInstance.HistoricalOhnoCounter)
-
-	return nil
-}
-
-func createHistoricalCounterForTest(db *sql.DB, tableName string) error {
-	var err error
-	rawCreateQuery := `CREATE TABLE IF NOT EXISTS %s (
-			counter_id UUID PRIMARY KEY NOT NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			value INT NOT NULL
-	);`
-	createQuery := fmt.Sprintf(rawCreateQuery, tableName)
-	_, err = db.Exec(createQuery)
-	if err != nil {
-		return fmt.Errorf("failed to create table %s: %w", tableName, err)
+	if err := migrateUp(strings.Replace(dsn, "postgres://", "pgx://", 1)); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
+
 	return nil
 }
 
@@ -126,8 +107,26 @@ func cleanupTable(t *testing.T, tableName string) {
 	}
 }
 
+func requirePostgres(t *testing.T) {
+	if store == nil {
+		t.Skip("Postgres test container is not available")
+	}
+}
+
+func setStateForTest(t *testing.T, state State) {
+	rawUpsertQuery := `
+		INSERT INTO %s (id, state)
+		VALUES (1, '%s')
+		ON CONFLICT (id) DO UPDATE SET state = EXCLUDED.state;
+	`
+	upsertQuery := fmt.Sprintf(rawUpsertQuery, utils.TableInstance.HealthState, state)
+	if _, err := db.Exec(upsertQuery); err != nil {
+		t.Fatalf("failed to set state: %s", err)
+	}
+}
+
 func teardown() error {
-	if err := db.Close(); err != nil {
+	if err := store.Close(); err != nil {
 		return fmt.Errorf("failed to close database connection: %w", err)
 	}
 	return postgresContainer.Terminate(context.Background())
@@ -137,12 +136,15 @@ func teardown() error {
 Test Cases
 */
 func TestGetCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	// Insert a row into the counter table
 	tableName := utils.TableInstance.Counter
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, is_locked, updated_at, reseted_at) 
+		INSERT INTO %s (current_value, updated_at, reseted_at) 
 		VALUES 
-			(42, false, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
+			(42, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -150,7 +152,7 @@ func TestGetCounter(t *testing.T) {
 		t.Fatalf("failed to insert into table: %s, err: %s", tableName, err)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -176,6 +178,9 @@ func TestGetCounter(t *testing.T) {
 }
 
 func TestGetCounterEmpty(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 
 	// Ensure table is empty
@@ -188,7 +193,7 @@ func TestGetCounterEmpty(t *testing.T) {
 		t.Fatalf("failed to clean up table: %s", err)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -214,6 +219,9 @@ func TestGetCounterEmpty(t *testing.T) {
 // simply need to create one with CurrentValue=1 and UpdatedAt=NOW(). ResetedAt should be a sql
 // null string
 func TestUpdateCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 	// Ensure db_test is not nil
 	if db == nil {
@@ -221,14 +229,16 @@ func TestUpdateCounter(t *testing.T) {
 	}
 
 	// Call the UpdateCounter function
-	isUpdated := UpdateCounter()
-
+	isUpdated, err := store.UpdateCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
 	// Test GetCounter function
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -270,13 +280,16 @@ func TestUpdateCounter(t *testing.T) {
 // and we simply need to update the counter. It is expected to increment the counter by
 // one and update the updated_at field to NOW().
 func TestUpdateCounterTypicalCase(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, max_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, max_value, updated_at) 
 		VALUES 
-			(42, 42, false, '2024-05-30 12:34:56');`
+			(42, 42, '2024-05-30 12:34:56');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -290,13 +303,15 @@ func TestUpdateCounterTypicalCase(t *testing.T) {
 	}
 
 	// Test UpdateCounter
-	isUpdated := UpdateCounter()
-
+	isUpdated, err := store.UpdateCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -337,13 +352,16 @@ func TestUpdateCounterTypicalCase(t *testing.T) {
 // one and update the updated_at field to NOW(). The max_value should not be updated because it is
 // lower than the current_value.
 func TestUpdateCounterTypicalCaseMaxValueNotReached(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, max_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, max_value, updated_at) 
 		VALUES 
-			(42, 100, false, '2024-05-30 12:34:56');`
+			(42, 100, '2024-05-30 12:34:56');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -357,13 +375,15 @@ func TestUpdateCounterTypicalCaseMaxValueNotReached(t *testing.T) {
 	}
 
 	// Test UpdateCounter
-	isUpdated := UpdateCounter()
-
+	isUpdated, err := store.UpdateCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -403,6 +423,9 @@ func TestUpdateCounterTypicalCaseMaxValueNotReached(t *testing.T) {
 // cannot update the counter because 24h did not pass since the last update.
 // It is expected that no counter is updated.
 func TestUpdateCounterTimeDidNotPass(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 	// Check updated_at (should be close to current time)
 	updatedLessThan24hAgo := time.Now().UTC().Add(-23 * time.Hour)
@@ -410,9 +433,9 @@ func TestUpdateCounterTimeDidNotPass(t *testing.T) {
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, updated_at) 
 		VALUES 
-			(42, false, '%s');`
+			(42, '%s');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName, parsedUpdatedLessThan24hAgo)
 
 	_, err := db.Exec(insertQuery)
@@ -426,13 +449,15 @@ func TestUpdateCounterTimeDidNotPass(t *testing.T) {
 	}
 
 	// Test UpdateCounter
-	isUpdated := UpdateCounter()
-
+	isUpdated, err := store.UpdateCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if isUpdated {
 		t.Errorf("expected isUpdated to be false, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -461,6 +486,9 @@ func TestUpdateCounterTimeDidNotPass(t *testing.T) {
 // NOTE: Resetting counter. Counter has no values - no entry exists, calling ResetCounter
 // should create a new counter element
 func TestResetCounterNoData(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 
 	// Ensure db_test is not nil
@@ -469,7 +497,7 @@ func TestResetCounterNoData(t *testing.T) {
 	}
 
 	// Test ResetCounter
-	lastValue, err := ResetCounter(tableName)
+	lastValue, err := store.ResetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to reset %s data: %s", tableName, err)
 	}
@@ -478,7 +506,7 @@ func TestResetCounterNoData(t *testing.T) {
 		t.Errorf("expected last value to be 1, got %d", lastValue)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -513,10 +541,13 @@ func TestResetCounterNoData(t *testing.T) {
 
 // NOTE: Counter has some value, resetting, should be zero now
 func TestResetCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.Counter
 	_, err := db.Exec(`
-		INSERT INTO counter (current_value, is_locked, updated_at, reseted_at) 
-		VALUES (42, false, '2024-05-30 12:34:56', '2024-05-01 12:00:00')
+		INSERT INTO counter (current_value, updated_at, reseted_at) 
+		VALUES (42, '2024-05-30 12:34:56', '2024-05-01 12:00:00')
 	`)
 	if err != nil {
 		t.Fatalf("failed to insert into table: %s", err)
@@ -528,7 +559,7 @@ func TestResetCounter(t *testing.T) {
 	}
 
 	// Test ResetCounter
-	lastValue, err := ResetCounter(tableName)
+	lastValue, err := store.ResetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed resetting %s data: %s", tableName, err)
 	}
@@ -537,7 +568,7 @@ func TestResetCounter(t *testing.T) {
 		t.Errorf("expected last value to be 42, got %d", lastValue)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get %s: %s", tableName, err)
 	}
@@ -571,12 +602,16 @@ func TestResetCounter(t *testing.T) {
 }
 
 func TestGetOhnoCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	// Insert a row into the ohno_counter table
 	tableName := utils.TableInstance.OhnoCounter
+	setStateForTest(t, Ill)
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, is_locked, updated_at, reseted_at) 
+		INSERT INTO %s (current_value, updated_at, reseted_at) 
 		VALUES 
-			(42, false, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
+			(42, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -584,7 +619,7 @@ func TestGetOhnoCounter(t *testing.T) {
 		t.Fatalf("failed to insert into table: %s, err: %s", tableName, err)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -607,9 +642,13 @@ func TestGetOhnoCounter(t *testing.T) {
 
 	// Cleanup table after test
 	cleanupTable(t, tableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
 }
 
 func TestGetOhnoCounterEmpty(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.OhnoCounter
 
 	// Ensure table is empty
@@ -622,7 +661,7 @@ func TestGetOhnoCounterEmpty(t *testing.T) {
 		t.Fatalf("failed to clean up table: %s", err)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -648,21 +687,27 @@ func TestGetOhnoCounterEmpty(t *testing.T) {
 // simply need to create one with CurrentValue=1 and UpdatedAt=NOW(). ResetedAt should be a sql
 // null string and MaxValue should be 1
 func TestUpdateOhnoCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.OhnoCounter
+	setStateForTest(t, Ill)
 	// Ensure db_test is not nil
 	if db == nil {
 		t.Fatal("db is nil")
 	}
 
 	// Call the UpdateOhnoCounter function
-	isUpdated := UpdateOhnoCounter()
-
+	isUpdated, err := store.UpdateOhnoCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
 	// Test GetCounter function
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -700,6 +745,7 @@ func TestUpdateOhnoCounter(t *testing.T) {
 
 	// Cleanup table after test
 	cleanupTable(t, tableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
 }
 
 // NOTE: Test covers a typical situation where there are some existing rows in the ohno_counter
@@ -707,13 +753,17 @@ func TestUpdateOhnoCounter(t *testing.T) {
 // one and update the updated_at field to NOW(). maxValue should be updated because it is lower
 // then currentValue
 func TestUpdateOhnoCounterTypicalCase(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.OhnoCounter
+	setStateForTest(t, Ill)
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, max_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, max_value, updated_at) 
 		VALUES 
-			(42, 42, false, '2024-05-30 12:34:56');`
+			(42, 42, '2024-05-30 12:34:56');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -726,13 +776,15 @@ func TestUpdateOhnoCounterTypicalCase(t *testing.T) {
 		t.Fatal("db is nil")
 	}
 
-	isUpdated := UpdateOhnoCounter()
-
+	isUpdated, err := store.UpdateOhnoCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -763,6 +815,7 @@ func TestUpdateOhnoCounterTypicalCase(t *testing.T) {
 
 	// Cleanup table after test
 	cleanupTable(t, tableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
 }
 
 // NOTE: Test covers a typical situation where there are some existing rows in the ohno_counter
@@ -770,13 +823,17 @@ func TestUpdateOhnoCounterTypicalCase(t *testing.T) {
 // one and update the updated_at field to NOW(). maxValue should not be updated because the
 // currentValue is too small
 func TestUpdateOhnoCounterTypicalCaseMaxValueNotReached(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.OhnoCounter
+	setStateForTest(t, Ill)
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, max_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, max_value, updated_at) 
 		VALUES 
-			(42, 200, false, '2024-05-30 12:34:56');`
+			(42, 200, '2024-05-30 12:34:56');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
 
 	_, err := db.Exec(insertQuery)
@@ -789,13 +846,15 @@ func TestUpdateOhnoCounterTypicalCaseMaxValueNotReached(t *testing.T) {
 		t.Fatal("db is nil")
 	}
 
-	isUpdated := UpdateOhnoCounter()
-
+	isUpdated, err := store.UpdateOhnoCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if !isUpdated {
 		t.Errorf("expected isUpdated to be true, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -826,13 +885,18 @@ func TestUpdateOhnoCounterTypicalCaseMaxValueNotReached(t *testing.T) {
 
 	// Cleanup table after test
 	cleanupTable(t, tableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
 }
 
 // NOTE: Test covers a situation where there are some existing rows in the ohno_counter table and
 // we cannot update the counter because 24h did not pass since the last update.
 // It is expected that no counter is updated.
 func TestUpdateOhnoCounterTimeDidNotPass(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	tableName := utils.TableInstance.OhnoCounter
+	setStateForTest(t, Ill)
 	// Check updated_at (should be close to current time)
 	updatedLessThan24hAgo := time.Now().UTC().Add(-23 * time.Hour)
 
@@ -840,9 +904,9 @@ func TestUpdateOhnoCounterTimeDidNotPass(t *testing.T) {
 
 	// Insert a row into the counter table
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, max_value, is_locked, updated_at) 
+		INSERT INTO %s (current_value, max_value, updated_at) 
 		VALUES 
-			(42, 42, false, '%s');`
+			(42, 42, '%s');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, tableName, parsedUpdatedLessThan24hAgo)
 
 	_, err := db.Exec(insertQuery)
@@ -856,13 +920,15 @@ func TestUpdateOhnoCounterTimeDidNotPass(t *testing.T) {
 	}
 
 	// Test UpdateOhnoCounter
-	isUpdated := UpdateOhnoCounter()
-
+	isUpdated, err := store.UpdateOhnoCounter(ctx)
+	if err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
 	if isUpdated {
 		t.Errorf("expected isUpdated to be false, got %v", isUpdated)
 	}
 
-	counter, err := GetCounter(tableName)
+	counter, err := store.GetCounter(ctx, tableName)
 	if err != nil {
 		t.Fatalf("failed to get counter: %s", err)
 	}
@@ -886,18 +952,22 @@ func TestUpdateOhnoCounterTimeDidNotPass(t *testing.T) {
 
 	// Cleanup table after test
 	cleanupTable(t, tableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
 }
 
 // NOTE: Test covers a situation when counter has some values and we create a historical counter
 // by recording ohno event
 func TestCreatHistoricalCounter(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	// Insert a row into the counter table
 	counterTableName := utils.TableInstance.Counter
 	historicalCounterTableName := utils.TableInstance.HistoricalCounter
 	rawInsertQuery := `
-		INSERT INTO %s (current_value, is_locked, updated_at, reseted_at) 
+		INSERT INTO %s (current_value, updated_at, reseted_at) 
 		VALUES 
-			(42, false, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
+			(42, '2024-05-30 12:34:56', '2024-05-01 12:00:00');`
 	insertQuery := fmt.Sprintf(rawInsertQuery, counterTableName)
 
 	_, err := db.Exec(insertQuery)
@@ -906,16 +976,16 @@ func TestCreatHistoricalCounter(t *testing.T) {
 	}
 
 	// Reset the counter
-	lastValue, err := ResetCounter(counterTableName)
+	lastValue, err := store.ResetCounter(ctx, counterTableName)
 	if err != nil {
 		t.Fatalf("failed to reset counter data: %s", err)
 	}
 
 	// Create historical counter entry
-	CreateHistoricalCounter(historicalCounterTableName, lastValue)
+	store.CreateHistoricalCounter(ctx, historicalCounterTableName, lastValue)
 
 	// Get historical counter
-	historicalCounter, err := GetHistoricalCounters(historicalCounterTableName)
+	historicalCounter, err := store.GetHistoricalCounters(ctx, historicalCounterTableName)
 	if err != nil {
 		t.Fatalf("failed to get %s: %s", historicalCounterTableName, err)
 	}
@@ -954,6 +1024,9 @@ func TestCreatHistoricalCounter(t *testing.T) {
 
 // NOTE: Test retrieves historical counter items
 func TestGetHistoricalCouters(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
 	// Insert a row into the counter table
 	historicalCounterTableName := utils.TableInstance.HistoricalCounter
 	rawInsertQuery := `
@@ -962,9 +1035,9 @@ func TestGetHistoricalCouters(t *testing.T) {
 `
 
 	entries := []HistoricalCounter{
-		{"123e4567-e89b-12d3-a456-426614174000", "2024-05-30 12:34:56", "2024-07-01 12:00:00", 42},
-		{"223e4567-e89b-12d3-a456-426614174001", "2024-05-31 13:34:56", "2024-07-02 13:00:00", 43},
-		{"323e4567-e89b-12d3-a456-426614174002", "2024-06-01 14:34:56", "2024-07-03 14:00:00", 44},
+		{CounterID: "123e4567-e89b-12d3-a456-426614174000", CreatedAt: "2024-05-30 12:34:56", UpdatedAt: "2024-07-01 12:00:00", Value: 42},
+		{CounterID: "223e4567-e89b-12d3-a456-426614174001", CreatedAt: "2024-05-31 13:34:56", UpdatedAt: "2024-07-02 13:00:00", Value: 43},
+		{CounterID: "323e4567-e89b-12d3-a456-426614174002", CreatedAt: "2024-06-01 14:34:56", UpdatedAt: "2024-07-03 14:00:00", Value: 44},
 	}
 
 	for _, entry := range entries {
@@ -976,7 +1049,7 @@ func TestGetHistoricalCouters(t *testing.T) {
 	}
 
 	// Get historical counters
-	historicalCounters, err := GetHistoricalCounters(historicalCounterTableName)
+	historicalCounters, err := store.GetHistoricalCounters(ctx, historicalCounterTableName)
 	if err != nil {
 		t.Fatalf("failed to get %s: %s", historicalCounterTableName, err)
 	}
@@ -995,3 +1068,205 @@ func TestGetHistoricalCouters(t *testing.T) {
 	// Cleanup table after test
 	cleanupTable(t, historicalCounterTableName)
 }
+
+// NOTE: Test covers recording an ohno event. Counter should be reset and locked, ohno_counter
+// unlocked and the last value of the counter should end up in historical_counter.
+func TestTransitionToIll(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
+	counterTableName := utils.TableInstance.Counter
+	ohnoCounterTableName := utils.TableInstance.OhnoCounter
+	historicalCounterTableName := utils.TableInstance.HistoricalCounter
+
+	_, err := db.Exec(`
+		INSERT INTO counter (current_value, updated_at, reseted_at)
+		VALUES (42, '2024-05-30 12:34:56', '2024-05-01 12:00:00')
+	`)
+	if err != nil {
+		t.Fatalf("failed to insert into table: %s, err: %s", counterTableName, err)
+	}
+	_, err = db.Exec(`
+		INSERT INTO ohno_counter (current_value, updated_at, reseted_at)
+		VALUES (1, '2024-05-01 12:00:00', '2024-05-01 12:00:00')
+	`)
+	if err != nil {
+		t.Fatalf("failed to insert into table: %s, err: %s", ohnoCounterTableName, err)
+	}
+
+	err = store.TransitionTo(ctx, Ill, time.Time{})
+	if err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	counter, err := store.GetCounter(ctx, counterTableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", counterTableName, err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if counter.IsLocked != true {
+		t.Errorf("expected isLocked to be true, got %v", counter.IsLocked)
+	}
+
+	ohnoCounter, err := store.GetCounter(ctx, ohnoCounterTableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", ohnoCounterTableName, err)
+	}
+	if ohnoCounter.IsLocked != false {
+		t.Errorf("expected isLocked to be false, got %v", ohnoCounter.IsLocked)
+	}
+
+	historicalCounters, err := store.GetHistoricalCounters(ctx, historicalCounterTableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", historicalCounterTableName, err)
+	}
+	if len(historicalCounters) != 1 {
+		t.Fatalf("expected 1 historical counter, got %d", len(historicalCounters))
+	}
+	if historicalCounters[0].Value != 42 {
+		t.Errorf("expected value to be 42, got %d", historicalCounters[0].Value)
+	}
+
+	// Cleanup table after test
+	cleanupTable(t, counterTableName)
+	cleanupTable(t, ohnoCounterTableName)
+	cleanupTable(t, historicalCounterTableName)
+	cleanupTable(t, utils.TableInstance.HealthState)
+}
+
+// NOTE: Test covers recording a fine event while already healthy. The transition should be
+// refused and nothing should be written.
+func TestTransitionToHealthyWhileHealthy(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
+	ohnoCounterTableName := utils.TableInstance.OhnoCounter
+	historicalOhnoCounterTableName := utils.TableInstance.HistoricalOhnoCounter
+
+	_, err := db.Exec(`
+		INSERT INTO ohno_counter (current_value, updated_at, reseted_at)
+		VALUES (5, '2024-05-30 12:34:56', '2024-05-01 12:00:00')
+	`)
+	if err != nil {
+		t.Fatalf("failed to insert into table: %s, err: %s", ohnoCounterTableName, err)
+	}
+
+	err = store.TransitionTo(ctx, Healthy, time.Time{})
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+
+	ohnoCounter, err := store.GetCounter(ctx, ohnoCounterTableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", ohnoCounterTableName, err)
+	}
+	if ohnoCounter.CurrentValue != 5 {
+		t.Errorf("expected current_value to be 5, got %d", ohnoCounter.CurrentValue)
+	}
+
+	historicalCounters, err := store.GetHistoricalCounters(ctx, historicalOhnoCounterTableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", historicalOhnoCounterTableName, err)
+	}
+	if len(historicalCounters) != 0 {
+		t.Errorf("expected 0 historical counters, got %d", len(historicalCounters))
+	}
+
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Healthy {
+		t.Errorf("expected state to be %s, got %s", Healthy, healthState.State)
+	}
+
+	// Cleanup table after test
+	cleanupTable(t, ohnoCounterTableName)
+}
+
+// NOTE: Test covers repairing a corrupted counter and lost historical rows by replaying the
+// event log.
+func TestRebuildFromEvents(t *testing.T) {
+	ctx := context.Background()
+	requirePostgres(t)
+
+	tables := []string{
+		utils.TableInstance.Events,
+		utils.TableInstance.Counter,
+		utils.TableInstance.OhnoCounter,
+		utils.TableInstance.HistoricalCounter,
+		utils.TableInstance.HistoricalOhnoCounter,
+		utils.TableInstance.HealthState,
+	}
+	for _, tableName := range tables {
+		cleanupTable(t, tableName)
+	}
+
+	if err := store.SetCounter(ctx, 41); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	if err := store.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+	if isUpdated, err := store.UpdateOhnoCounter(ctx); err != nil || !isUpdated {
+		t.Fatalf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+	if err := store.TransitionTo(ctx, Healthy, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Healthy, err)
+	}
+
+	// Corrupt the counter and lose the historical rows
+	if _, err := db.Exec(`UPDATE counter SET current_value = 999`); err != nil {
+		t.Fatalf("failed to update counter: %s", err)
+	}
+	cleanupTable(t, utils.TableInstance.HistoricalCounter)
+
+	if err := store.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+
+	counter, err := store.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if counter.IsLocked != false {
+		t.Errorf("expected isLocked to be false, got %v", counter.IsLocked)
+	}
+
+	historicalCounters, err := store.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", utils.TableInstance.HistoricalCounter, err)
+	}
+	if len(historicalCounters) != 1 {
+		t.Fatalf("expected 1 historical counter, got %d", len(historicalCounters))
+	}
+	if historicalCounters[0].Value != 41 {
+		t.Errorf("expected value to be 41, got %d", historicalCounters[0].Value)
+	}
+
+	historicalOhnoCounters, err := store.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", utils.TableInstance.HistoricalOhnoCounter, err)
+	}
+	if len(historicalOhnoCounters) != 1 {
+		t.Fatalf("expected 1 historical ohno counter, got %d", len(historicalOhnoCounters))
+	}
+
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Healthy {
+		t.Errorf("expected state to be %s, got %s", Healthy, healthState.State)
+	}
+
+	// Cleanup tables after test
+	for _, tableName := range tables {
+		cleanupTable(t, tableName)
+	}
+}
@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"server/utils"
+)
+
+type EventKind string
+
+const (
+	// EventSnapshot captures the counters and state at the moment the event log was created.
+	// Replaying starts from the latest snapshot.
+	EventSnapshot  EventKind = "snapshot"
+	EventOhno      EventKind = "ohno"
+	EventFine      EventKind = "fine"
+	EventTick      EventKind = "tick"
+	EventManualSet EventKind = "manual_set"
+	EventUndo      EventKind = "undo"
+	EventImport    EventKind = "import"
+)
+
+type Event struct {
+	EventID    int64           `json:"event_id"`
+	Kind       EventKind       `json:"kind"`
+	OccurredAt string          `json:"occurred_at"`
+	CreatedAt  string          `json:"created_at"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// TransitionPayload is recorded with ohno and fine events. CounterID is the id of the
+// historical row created by the transition, empty if no row was created.
+// MovedTicks counts the ticks the reset counter received after a backdated transition. They
+// are taken off the reset counter and credited to the counter the transition activates, the
+// latest of them at LastTickAt.
+// Undo holds what the transition overwrote, it is missing on transitions recorded before undo
+// was supported.
+type TransitionPayload struct {
+	CounterID  string          `json:"counter_id,omitempty"`
+	Value      int             `json:"value"`
+	MovedTicks int             `json:"moved_ticks,omitempty"`
+	LastTickAt string          `json:"last_tick_at,omitempty"`
+	Undo       *TransitionUndo `json:"undo,omitempty"`
+}
+
+// TransitionUndo is the state and the counter rows as they were before a transition. A nil
+// counter means the row did not exist. ActivatedCounter is only recorded when ticks were moved
+// to it.
+type TransitionUndo struct {
+	ChangedAt        *string          `json:"changed_at"`
+	ResetCounter     *SnapshotCounter `json:"reset_counter"`
+	ActivatedCounter *SnapshotCounter `json:"activated_counter,omitempty"`
+}
+
+// UndoPayload is recorded with undo events. EventID is the id of the transition undone.
+type UndoPayload struct {
+	EventID int64 `json:"event_id"`
+}
+
+// TickPayload is recorded with tick events. Value is the counter value after the increment.
+type TickPayload struct {
+	Table string `json:"table"`
+	Value int    `json:"value"`
+}
+
+type ManualSetPayload struct {
+	Value int `json:"value"`
+}
+
+type SnapshotCounter struct {
+	CurrentValue int     `json:"current_value"`
+	MaxValue     int     `json:"max_value"`
+	UpdatedAt    string  `json:"updated_at"`
+	ResetedAt    *string `json:"reseted_at"`
+}
+
+// SnapshotPayload is recorded with snapshot events. A nil counter means the counter row did not
+// exist when the snapshot was taken.
+type SnapshotPayload struct {
+	State       State            `json:"state"`
+	ChangedAt   *string          `json:"changed_at"`
+	Counter     *SnapshotCounter `json:"counter"`
+	OhnoCounter *SnapshotCounter `json:"ohno_counter"`
+}
+
+// appendEvent appends an event of the given kind, occurring now, to the event log within the
+// given transaction. The log is append-only, events are never updated or deleted.
+func (s *SQLStore) appendEvent(tx *sql.Tx, kind EventKind, payload any) error {
+	return s.appendEventAt(tx, kind, payload, "")
+}
+
+// appendEventAt is appendEvent for events that occurred at occurredAt, empty meaning now.
+func (s *SQLStore) appendEventAt(tx *sql.Tx, kind EventKind, payload any, occurredAt string) error {
+	tableName := utils.TableInstance.Events
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("❌ Error marshaling %s event payload.\n %s", kind, err)
+	}
+
+	occurredAtValue := s.dialect.now
+	args := []any{string(kind), string(payloadJson)}
+	if occurredAt != "" {
+		occurredAtValue = "$3"
+		args = append(args, occurredAt)
+	}
+
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (kind, payload, occurred_at)
+		VALUES ($1, $2, %s)
+	`, tableName, occurredAtValue)
+
+	_, err = tx.Exec(insertQuery, args...)
+	if err != nil {
+		return fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+	}
+	return nil
+}
+
+func (s *SQLStore) GetEvents(ctx context.Context) ([]Event, error) {
+	tableName := utils.TableInstance.Events
+	query := fmt.Sprintf(`
+		SELECT
+			event_id, kind, occurred_at, created_at, payload
+		FROM %s
+		ORDER BY event_id
+	`, tableName)
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+// stateEventKinds are the kinds of the events that set the state.
+var stateEventKinds = []EventKind{EventSnapshot, EventOhno, EventFine, EventUndo}
+
+func (s *SQLStore) GetLatestStateEvents(ctx context.Context, limit int) ([]Event, error) {
+	tableName := utils.TableInstance.Events
+	query := fmt.Sprintf(`
+		SELECT
+			event_id, kind, occurred_at, created_at, payload
+		FROM %s
+		WHERE kind IN ($1, $2, $3, $4)
+		ORDER BY event_id DESC
+		LIMIT $5
+	`, tableName)
+
+	rows, err := s.db.QueryContext(ctx, query, string(EventSnapshot), string(EventOhno), string(EventFine), string(EventUndo), limit)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	defer rows.Close()
+
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	var events []Event
+
+	for rows.Next() {
+		var event Event
+		var payload string
+		err := rows.Scan(&event.EventID, &event.Kind, &event.OccurredAt, &event.CreatedAt, &payload)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error scanning row.\n %s", err)
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("❌ Row iteration error.\n %s", err)
+	}
+
+	return events, nil
+}
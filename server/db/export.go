@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"server/utils"
+	"strconv"
+)
+
+// ExportVersion is the version of the export layout. It is bumped whenever a field is added,
+// removed or changes meaning, so that imports can tell which layout they are reading.
+const ExportVersion = 1
+
+// ExportRecord is a row of one of the exported tables. Counter rows have no counter_id,
+// created_at, started_at or ended_at, historical rows have no max_value or reseted_at.
+type ExportRecord struct {
+	Table     string  `json:"table"`
+	CounterID *string `json:"counter_id"`
+	Value     int     `json:"value"`
+	MaxValue  *int    `json:"max_value"`
+	CreatedAt *string `json:"created_at"`
+	UpdatedAt string  `json:"updated_at"`
+	ResetedAt *string `json:"reseted_at"`
+	StartedAt *string `json:"started_at"`
+	EndedAt   *string `json:"ended_at"`
+}
+
+// ExportCSVHeader is the header row of CSV exports, one column per field of ExportRecord
+// preceded by the layout version.
+var ExportCSVHeader = []string{"version", "table", "counter_id", "value", "max_value", "created_at", "updated_at", "reseted_at", "started_at", "ended_at"}
+
+// CSVRow returns the record as a CSV row matching ExportCSVHeader, nil fields are empty.
+func (r ExportRecord) CSVRow() []string {
+	maxValue := ""
+	if r.MaxValue != nil {
+		maxValue = strconv.Itoa(*r.MaxValue)
+	}
+	optional := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return *value
+	}
+	return []string{
+		strconv.Itoa(ExportVersion),
+		r.Table,
+		optional(r.CounterID),
+		strconv.Itoa(r.Value),
+		maxValue,
+		optional(r.CreatedAt),
+		r.UpdatedAt,
+		optional(r.ResetedAt),
+		optional(r.StartedAt),
+		optional(r.EndedAt),
+	}
+}
+
+// ExportTables lists the exported tables in export order.
+func ExportTables() []string {
+	return []string{
+		utils.TableInstance.Counter,
+		utils.TableInstance.OhnoCounter,
+		utils.TableInstance.HistoricalCounter,
+		utils.TableInstance.HistoricalOhnoCounter,
+	}
+}
+
+func isCounterTable(tableName string) bool {
+	return tableName == utils.TableInstance.Counter || tableName == utils.TableInstance.OhnoCounter
+}
+
+func (s *SQLStore) Export(ctx context.Context, fn func(ExportRecord) error) error {
+	// Read-only, so that SQLite begins a deferred transaction instead of taking the write lock
+	// for as long as the client downloads. WAL keeps the snapshot while writers go on.
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: s.dialect.snapshot, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+	// Nothing is written, the transaction only provides the snapshot
+	defer tx.Rollback()
+
+	for _, tableName := range ExportTables() {
+		err = s.exportTable(tx, tableName, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) exportTable(tx *sql.Tx, tableName string, fn func(ExportRecord) error) error {
+	var query string
+	if isCounterTable(tableName) {
+		query = fmt.Sprintf(`
+			SELECT
+				NULL, current_value, COALESCE(max_value, 0), NULL, updated_at, reseted_at, NULL, NULL
+			FROM %s
+		`, tableName)
+	} else {
+		query = fmt.Sprintf(`
+			SELECT
+				counter_id, value, NULL, created_at, updated_at, NULL, started_at, ended_at
+			FROM %s
+			ORDER BY %s, counter_id
+		`, tableName, s.dialect.timestamp("created_at"))
+	}
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := ExportRecord{Table: tableName}
+		var counterId, createdAt, resetedAt, startedAt, endedAt sql.NullString
+		var maxValue sql.NullInt64
+		err := rows.Scan(&counterId, &record.Value, &maxValue, &createdAt, &record.UpdatedAt, &resetedAt, &startedAt, &endedAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error scanning row.\n %s", err)
+		}
+		record.CounterID = nullableString(counterId)
+		record.CreatedAt = nullableString(createdAt)
+		record.ResetedAt = nullableString(resetedAt)
+		record.StartedAt = nullableString(startedAt)
+		record.EndedAt = nullableString(endedAt)
+		if maxValue.Valid {
+			value := int(maxValue.Int64)
+			record.MaxValue = &value
+		}
+
+		err = fn(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("❌ Row iteration error.\n %s", err)
+	}
+	return nil
+}
+
+func nullableString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
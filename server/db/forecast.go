@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"server/utils"
+	"sort"
+)
+
+const (
+	DefaultForecastDays = 30
+	MaxForecastDays     = 365
+)
+
+// SurvivalPoint is the probability that the current healthy streak lasts more than Days
+// further days.
+type SurvivalPoint struct {
+	Days        int     `json:"days"`
+	Probability float64 `json:"probability"`
+}
+
+// Forecast estimates how long the current healthy streak lasts with a Kaplan–Meier survival
+// curve over the finished streaks. The open streak, when healthy, is a censored observation.
+// ExpectedRemainingDays is the mean remaining time restricted to the longest streak observed,
+// nil when the curve has already dropped to zero at the current streak.
+type Forecast struct {
+	CurrentStreak         int             `json:"current_streak"`
+	Observations          int             `json:"observations"`
+	Censored              int             `json:"censored"`
+	Curve                 []SurvivalPoint `json:"curve"`
+	ExpectedRemainingDays *float64        `json:"expected_remaining_days"`
+}
+
+// survivalStep is a drop of the Kaplan–Meier curve, survival holds from at onwards.
+type survivalStep struct {
+	at       int
+	survival float64
+}
+
+// survivalCurve is the Kaplan–Meier estimate of the probability that a streak lasts more than
+// a given number of days.
+type survivalCurve struct {
+	steps []survivalStep
+	// longest is the longest streak observed, finished or not
+	longest int
+}
+
+func kaplanMeier(finished []int, censored []int) survivalCurve {
+	curve := survivalCurve{}
+	events := map[int]int{}
+	for _, duration := range finished {
+		events[duration]++
+		curve.longest = max(curve.longest, duration)
+	}
+	for _, duration := range censored {
+		curve.longest = max(curve.longest, duration)
+	}
+
+	var times []int
+	for duration := range events {
+		times = append(times, duration)
+	}
+	sort.Ints(times)
+
+	survival := 1.0
+	for _, at := range times {
+		// Streaks censored at the time of an event were still at risk
+		atRisk := 0
+		for _, duration := range finished {
+			if duration >= at {
+				atRisk++
+			}
+		}
+		for _, duration := range censored {
+			if duration >= at {
+				atRisk++
+			}
+		}
+		survival *= 1 - float64(events[at])/float64(atRisk)
+		curve.steps = append(curve.steps, survivalStep{at: at, survival: survival})
+	}
+	return curve
+}
+
+// at returns the probability that a streak lasts more than days.
+func (c survivalCurve) at(days int) float64 {
+	survival := 1.0
+	for _, step := range c.steps {
+		if step.at > days {
+			break
+		}
+		survival = step.survival
+	}
+	return survival
+}
+
+// area integrates the curve between from and to.
+func (c survivalCurve) area(from int, to int) float64 {
+	area := 0.0
+	for days := from; days < to; days++ {
+		area += c.at(days)
+	}
+	return area
+}
+
+// BuildForecast computes the survival curve of the current healthy streak over the next days.
+func BuildForecast(ctx context.Context, store CounterStore, days int) (Forecast, error) {
+	historicalCounters, err := store.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		return Forecast{}, err
+	}
+	var finished []int
+	for _, historicalCounter := range historicalCounters {
+		finished = append(finished, historicalCounter.Value)
+	}
+
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		return Forecast{}, err
+	}
+	var censored []int
+	current := 0
+	if healthState.State == Healthy {
+		counter, err := store.GetCounter(ctx, utils.TableInstance.Counter)
+		if err != nil {
+			return Forecast{}, err
+		}
+		current = counter.CurrentValue
+		censored = append(censored, current)
+	}
+
+	curve := kaplanMeier(finished, censored)
+	forecast := Forecast{
+		CurrentStreak: current,
+		Observations:  len(finished) + len(censored),
+		Censored:      len(censored),
+		Curve:         []SurvivalPoint{},
+	}
+
+	survivedSoFar := curve.at(current)
+	if survivedSoFar == 0 {
+		for n := 0; n <= days; n++ {
+			forecast.Curve = append(forecast.Curve, SurvivalPoint{Days: n, Probability: 0})
+		}
+		return forecast, nil
+	}
+	for n := 0; n <= days; n++ {
+		forecast.Curve = append(forecast.Curve, SurvivalPoint{Days: n, Probability: curve.at(current+n) / survivedSoFar})
+	}
+	expectedRemainingDays := curve.area(current, curve.longest) / survivedSoFar
+	forecast.ExpectedRemainingDays = &expectedRemainingDays
+	return forecast, nil
+}
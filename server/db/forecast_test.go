@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestKaplanMeier(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		finished []int
+		censored []int
+		// expected survival from 0 days onwards
+		expected []float64
+		longest  int
+	}{
+		{
+			name:     "no streak",
+			expected: []float64{1, 1, 1},
+		},
+		{
+			name:     "censored only",
+			censored: []int{2},
+			expected: []float64{1, 1, 1, 1},
+			longest:  2,
+		},
+		{
+			// S(2) = 3/4, S(4) = 3/4 * 1/3 and S(6) = 0
+			name:     "finished only",
+			finished: []int{2, 4, 4, 6},
+			expected: []float64{1, 1, 0.75, 0.75, 0.25, 0.25, 0, 0},
+			longest:  6,
+		},
+		{
+			// The streak censored at 3 is at risk at 2 but not at 4: S(2) = 4/5, S(4) = 4/5 * 1/3
+			name:     "finished and censored",
+			finished: []int{2, 4, 4, 6},
+			censored: []int{3},
+			expected: []float64{1, 1, 0.8, 0.8, 0.8 / 3, 0.8 / 3, 0},
+			longest:  6,
+		},
+		{
+			// A streak censored at the time of an event was still at risk
+			name:     "censored at an event",
+			finished: []int{2},
+			censored: []int{2},
+			expected: []float64{1, 1, 0.5, 0.5},
+			longest:  2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			curve := kaplanMeier(tc.finished, tc.censored)
+			for days, expected := range tc.expected {
+				if survival := curve.at(days); math.Abs(survival-expected) > 1e-9 {
+					t.Errorf("expected %f after %d days, got %f", expected, days, survival)
+				}
+			}
+			if curve.longest != tc.longest {
+				t.Errorf("expected the longest streak to be %d, got %d", tc.longest, curve.longest)
+			}
+		})
+	}
+}
+
+func TestSurvivalCurveArea(t *testing.T) {
+	curve := kaplanMeier([]int{2, 4, 4, 6}, []int{3})
+	for _, tc := range []struct {
+		from     int
+		to       int
+		expected float64
+	}{
+		{0, 0, 0},
+		{0, 2, 2},
+		{3, 6, 0.8 + 2*0.8/3},
+		{6, 10, 0},
+	} {
+		if area := curve.area(tc.from, tc.to); math.Abs(area-tc.expected) > 1e-9 {
+			t.Errorf("expected an area of %f from %d to %d days, got %f", tc.expected, tc.from, tc.to, area)
+		}
+	}
+}
+
+func TestBuildForecast(t *testing.T) {
+	// Finished streaks of 2, 4, 4 and 6
+	var periods []fixturePeriod
+	for i, value := range []int{2, 4, 4, 6} {
+		periods = append(periods,
+			fixturePeriod{kind: Healthy, endedAt: date(2024, time.Month(2*i+1), 1), value: value},
+			fixturePeriod{kind: Ill, endedAt: date(2024, time.Month(2*i+2), 1), value: 1},
+		)
+	}
+
+	for _, tc := range []struct {
+		name                  string
+		state                 State
+		current               int
+		expectedObservations  int
+		expectedCensored      int
+		expectedCurve         []float64
+		expectedRemainingDays float64
+	}{
+		{
+			// S(3) = 0.8, S(4) = 0.8 * 1/3 and S(6) = 0, (0.8 + 2 * 0.8/3) / 0.8 days remain
+			name:                  "healthy",
+			state:                 Healthy,
+			current:               3,
+			expectedObservations:  5,
+			expectedCensored:      1,
+			expectedCurve:         []float64{1, 1.0 / 3, 1.0 / 3, 0, 0},
+			expectedRemainingDays: 5.0 / 3,
+		},
+		{
+			name:                  "ill",
+			state:                 Ill,
+			current:               2,
+			expectedObservations:  4,
+			expectedCurve:         []float64{1, 1, 0.75, 0.75, 0.25},
+			expectedRemainingDays: 1 + 1 + 0.75 + 0.75 + 0.25 + 0.25,
+		},
+		{
+			// No streak ended after 7 days, the curve stays flat
+			name:                  "longer than every streak",
+			state:                 Healthy,
+			current:               7,
+			expectedObservations:  5,
+			expectedCensored:      1,
+			expectedCurve:         []float64{1, 1, 1, 1, 1},
+			expectedRemainingDays: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := timelineFixture{periods: periods, state: tc.state, changedAt: date(2024, 9, 1), counter: tc.current}.store()
+			forecast, err := BuildForecast(context.Background(), store, len(tc.expectedCurve)-1)
+			if err != nil {
+				t.Fatalf("failed to build forecast: %s", err)
+			}
+
+			expectedStreak := 0
+			if tc.state == Healthy {
+				expectedStreak = tc.current
+			}
+			if forecast.CurrentStreak != expectedStreak || forecast.Observations != tc.expectedObservations || forecast.Censored != tc.expectedCensored {
+				t.Errorf("expected a current streak of %d, %d observations with %d censored, got %+v", expectedStreak, tc.expectedObservations, tc.expectedCensored, forecast)
+			}
+			if len(forecast.Curve) != len(tc.expectedCurve) {
+				t.Fatalf("expected %d points, got %+v", len(tc.expectedCurve), forecast.Curve)
+			}
+			for i, point := range forecast.Curve {
+				if point.Days != i || math.Abs(point.Probability-tc.expectedCurve[i]) > 1e-9 {
+					t.Errorf("expected %f after %d days, got %+v", tc.expectedCurve[i], i, point)
+				}
+			}
+			assertFloat(t, "expected_remaining_days", forecast.ExpectedRemainingDays, tc.expectedRemainingDays)
+		})
+	}
+}
@@ -1,32 +1,36 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"server/utils"
 )
 
-func GetCounter(tableName string) (Counter, error) {
+// GetCounter returns the counter stored in tableName. IsLocked is derived from the current
+// state, a counter is locked whenever it is not the active counter of that state.
+func (s *SQLStore) GetCounter(ctx context.Context, tableName string) (Counter, error) {
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		return Counter{}, err
+	}
+	isLocked := healthState.State.ActiveCounter() != tableName
+
 	var counter Counter
 	query := fmt.Sprintf(`
 		SELECT
-			current_value, max_value, is_locked, updated_at, reseted_at
+			current_value, max_value, updated_at, reseted_at
 		FROM %s 
 		LIMIT 1
 		`, tableName)
-	row := db.QueryRow(query)
-	err := row.Scan(&counter.CurrentValue, &counter.MaxValue, &counter.IsLocked, &counter.UpdatedAt, &counter.ResetedAt)
+	row := s.db.QueryRowContext(ctx, query)
+	err = row.Scan(&counter.CurrentValue, &counter.MaxValue, &counter.UpdatedAt, &counter.ResetedAt)
 
 	if err != nil {
-		defaultIsLocked := false
-		if tableName == utils.TableInstance.OhnoCounter {
-			defaultIsLocked = true
-		}
 		if err == sql.ErrNoRows {
 			emptyCounter := Counter{
 				CurrentValue: 0,
 				MaxValue:     0,
-				IsLocked:     defaultIsLocked,
+				IsLocked:     isLocked,
 				UpdatedAt:    "",
 				ResetedAt:    sql.NullString{},
 			}
@@ -34,5 +38,6 @@ func GetCounter(tableName string) (Counter, error) {
 		}
 		return Counter{}, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
 	}
+	counter.IsLocked = isLocked
 	return counter, nil
 }
@@ -1,43 +1,138 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"strings"
 )
 
+// HistoricalCounter is a finished healthy or ill period. StartedAt is the transition that
+// started it, nil when unknown, and EndedAt is the transition that ended it.
 type HistoricalCounter struct {
 	CounterID string
 	CreatedAt string
 	UpdatedAt string
+	StartedAt *string
+	EndedAt   *string
 	Value     int
 }
 
-func GetHistoricalCounters(tableName string) ([]HistoricalCounter, error) {
+// GetHistoricalCounters returns every row of the historical table, oldest first.
+func (s *SQLStore) GetHistoricalCounters(ctx context.Context, tableName string) ([]HistoricalCounter, error) {
 	rawQuery := `
 		SELECT 
-			counter_id, updated_at, created_at, value 
+			counter_id, created_at, updated_at, started_at, ended_at, value 
 		FROM 
-			%s;
+			%s
+		ORDER BY %s, counter_id;
 	`
-	query := fmt.Sprintf(rawQuery, tableName)
+	query := fmt.Sprintf(rawQuery, tableName, s.dialect.timestamp("created_at"))
 
-	rows, err := db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
 	}
+	return scanHistoricalCounters(rows)
+}
+
+// ListHistoricalCounters returns a page of the historical table matching the query.
+func (s *SQLStore) ListHistoricalCounters(ctx context.Context, tableName string, query HistoricalQuery) (HistoricalPage, error) {
+	err := query.normalize()
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+	cursor, err := query.cursor()
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+
+	var conditions []string
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	createdAt := s.dialect.timestamp("created_at")
+
+	if query.From != nil {
+		conditions = append(conditions, fmt.Sprintf("%s >= %s", createdAt, s.dialect.timestamp(arg(formatSQLTimestamp(*query.From)))))
+	}
+	if query.To != nil {
+		conditions = append(conditions, fmt.Sprintf("%s < %s", createdAt, s.dialect.timestamp(arg(formatSQLTimestamp(*query.To)))))
+	}
+	if query.MinValue != nil {
+		conditions = append(conditions, fmt.Sprintf("value >= %s", arg(*query.MinValue)))
+	}
+	if query.MaxValue != nil {
+		conditions = append(conditions, fmt.Sprintf("value <= %s", arg(*query.MaxValue)))
+	}
+
+	sortKey := createdAt
+	if query.Sort.byValue() {
+		sortKey = "value"
+	}
+	operator, direction := ">", "ASC"
+	if query.Sort.descending() {
+		operator, direction = "<", "DESC"
+	}
+
+	if cursor != nil {
+		key := s.dialect.timestamp(arg(cursor.CreatedAt))
+		if query.Sort.byValue() {
+			key = arg(cursor.Value)
+		}
+		counterId := arg(cursor.CounterID)
+		conditions = append(conditions, fmt.Sprintf("(%s %s %s OR (%s = %s AND counter_id %s %s))", sortKey, operator, key, sortKey, key, operator, counterId))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	listQuery := fmt.Sprintf(`
+		SELECT
+			counter_id, created_at, updated_at, started_at, ended_at, value
+		FROM %s
+		%s
+		ORDER BY %s %s, counter_id %s
+		LIMIT %d
+	`, tableName, where, sortKey, direction, direction, query.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, listQuery, args...)
+	if err != nil {
+		return HistoricalPage{}, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	historicalCounters, err := scanHistoricalCounters(rows)
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+	return query.page(historicalCounters)
+}
+
+func scanHistoricalCounters(rows *sql.Rows) ([]HistoricalCounter, error) {
 	defer rows.Close()
 
 	var historicalCounters []HistoricalCounter
 
 	for rows.Next() {
 		var historicalCounter HistoricalCounter
-		err := rows.Scan(&historicalCounter.CounterID, &historicalCounter.CreatedAt, &historicalCounter.UpdatedAt, &historicalCounter.Value)
+		var startedAt, endedAt sql.NullString
+		err := rows.Scan(&historicalCounter.CounterID, &historicalCounter.CreatedAt, &historicalCounter.UpdatedAt, &startedAt, &endedAt, &historicalCounter.Value)
 		if err != nil {
 			return nil, fmt.Errorf("❌ Error scanning row.\n %s", err)
 		}
+		if startedAt.Valid {
+			historicalCounter.StartedAt = &startedAt.String
+		}
+		if endedAt.Valid {
+			historicalCounter.EndedAt = &endedAt.String
+		}
 		historicalCounters = append(historicalCounters, historicalCounter)
 	}
 
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("❌ Row iteration error.\n %s", err)
 	}
 
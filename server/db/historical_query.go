@@ -0,0 +1,137 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// HistoricalSort orders historical rows by created_at, the end of their period, or by value.
+// A leading "-" sorts descending. Rows with equal keys are ordered by counter_id.
+type HistoricalSort string
+
+const (
+	SortCreatedAtAsc  HistoricalSort = "created_at"
+	SortCreatedAtDesc HistoricalSort = "-created_at"
+	SortValueAsc      HistoricalSort = "value"
+	SortValueDesc     HistoricalSort = "-value"
+)
+
+const (
+	DefaultHistoricalLimit = 50
+	MaxHistoricalLimit     = 500
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Valid reports whether s is one of the supported sort orders.
+func (s HistoricalSort) Valid() bool {
+	switch s {
+	case SortCreatedAtAsc, SortCreatedAtDesc, SortValueAsc, SortValueDesc:
+		return true
+	}
+	return false
+}
+
+func (s HistoricalSort) descending() bool {
+	return s == SortCreatedAtDesc || s == SortValueDesc
+}
+
+func (s HistoricalSort) byValue() bool {
+	return s == SortValueAsc || s == SortValueDesc
+}
+
+// HistoricalQuery filters and pages historical rows. From is inclusive and To exclusive, both
+// apply to created_at. Cursor is the NextCursor of the previous page, empty for the first one.
+type HistoricalQuery struct {
+	From     *time.Time
+	To       *time.Time
+	MinValue *int
+	MaxValue *int
+	Sort     HistoricalSort
+	Limit    int
+	Cursor   string
+}
+
+// HistoricalPage is a page of historical rows. NextCursor is nil on the last page.
+type HistoricalPage struct {
+	Items      []HistoricalCounter `json:"items"`
+	NextCursor *string             `json:"next_cursor"`
+}
+
+// historicalCursor is the position after the last row of a page, encoded as base64 JSON.
+type historicalCursor struct {
+	Sort      HistoricalSort `json:"sort"`
+	CreatedAt string         `json:"created_at"`
+	Value     int            `json:"value"`
+	CounterID string         `json:"counter_id"`
+}
+
+func (q *HistoricalQuery) normalize() error {
+	if q.Sort == "" {
+		q.Sort = SortCreatedAtAsc
+	}
+	if !q.Sort.Valid() {
+		return fmt.Errorf("❌ Error querying historical counters. Unknown sort: %s", q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultHistoricalLimit
+	}
+	q.Limit = min(q.Limit, MaxHistoricalLimit)
+	return nil
+}
+
+func (q HistoricalQuery) cursor() (*historicalCursor, error) {
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	var cursor historicalCursor
+	err = json.Unmarshal(raw, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	if cursor.Sort != q.Sort {
+		return nil, fmt.Errorf("%w: cursor was issued for sort %s", ErrInvalidCursor, cursor.Sort)
+	}
+	if _, err := parseTimestamp(cursor.CreatedAt); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+	}
+	return &cursor, nil
+}
+
+// page cuts rows, fetched with one row more than the limit, down to the limit and sets the
+// cursor of the next page if there is one.
+func (q HistoricalQuery) page(rows []HistoricalCounter) (HistoricalPage, error) {
+	page := HistoricalPage{Items: rows}
+	if page.Items == nil {
+		page.Items = []HistoricalCounter{}
+	}
+	if len(rows) <= q.Limit {
+		return page, nil
+	}
+
+	page.Items = rows[:q.Limit]
+	last := page.Items[q.Limit-1]
+	createdAt, err := parseTimestamp(last.CreatedAt)
+	if err != nil {
+		return HistoricalPage{}, fmt.Errorf("❌ Error parsing created_at timestamp.\n %s", err)
+	}
+	raw, err := json.Marshal(historicalCursor{
+		Sort:      q.Sort,
+		CreatedAt: formatTimestamp(createdAt),
+		Value:     last.Value,
+		CounterID: last.CounterID,
+	})
+	if err != nil {
+		return HistoricalPage{}, fmt.Errorf("❌ Error encoding cursor.\n %s", err)
+	}
+	nextCursor := base64.RawURLEncoding.EncodeToString(raw)
+	page.NextCursor = &nextCursor
+	return page, nil
+}
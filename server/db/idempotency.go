@@ -0,0 +1,117 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"server/utils"
+	"time"
+)
+
+// ErrIdempotencyKeyInFlight is returned while the request that reserved the key is still
+// running.
+var ErrIdempotencyKeyInFlight = errors.New("idempotency key in flight")
+
+// ErrIdempotencyKeyReused is returned for a key already used for another request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused for another request")
+
+// IdempotentResponse is the response stored for an idempotency key, replayed for requests
+// repeating the key.
+type IdempotentResponse struct {
+	StatusCode int
+	Body       string
+}
+
+// ReserveIdempotencyKey reserves key within scope, the caller, for the request identified by
+// requestHash, forgetting keys older than ttl and reservations whose request did not complete
+// within inFlightTimeout. It returns nil if the key was free, so that the request has to run and
+// complete or release the key afterwards, and the stored response if the request already ran.
+func (s *SQLStore) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, ttl time.Duration, inFlightTimeout time.Duration) (*IdempotentResponse, error) {
+	tableName := utils.TableInstance.IdempotencyKeys
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	createdAt := s.dialect.timestamp("created_at")
+	deleteQuery := fmt.Sprintf(`
+		DELETE FROM %s
+		WHERE %s < %s OR (status_code IS NULL AND %s < %s)
+	`, tableName, createdAt, s.dialect.timestamp("$1"), createdAt, s.dialect.timestamp("$2"))
+	_, err = tx.ExecContext(ctx, deleteQuery, formatSQLTimestamp(now.Add(-ttl)), formatSQLTimestamp(now.Add(-inFlightTimeout)))
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error deleting expired %s rows.\n %s", tableName, err)
+	}
+
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (scope, idempotency_key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (scope, idempotency_key) DO NOTHING
+	`, tableName)
+	result, err := tx.ExecContext(ctx, insertQuery, scope, key, requestHash, formatSQLTimestamp(now))
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+	}
+
+	if inserted == 0 {
+		var storedRequestHash string
+		var statusCode sql.NullInt64
+		var body sql.NullString
+		selectQuery := fmt.Sprintf(`
+			SELECT
+				request_hash, status_code, response_body
+			FROM %s
+			WHERE scope = $1 AND idempotency_key = $2
+		`, tableName)
+		err = tx.QueryRowContext(ctx, selectQuery, scope, key).Scan(&storedRequestHash, &statusCode, &body)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+		}
+		if storedRequestHash != requestHash {
+			return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, key)
+		}
+		if !statusCode.Valid {
+			return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyInFlight, key)
+		}
+		return &IdempotentResponse{StatusCode: int(statusCode.Int64), Body: body.String}, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	return nil, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request that reserved key within scope.
+func (s *SQLStore) CompleteIdempotencyKey(ctx context.Context, scope string, key string, response IdempotentResponse) error {
+	tableName := utils.TableInstance.IdempotencyKeys
+	updateQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET status_code = $1, response_body = $2
+		WHERE scope = $3 AND idempotency_key = $4
+	`, tableName)
+	_, err := s.db.ExecContext(ctx, updateQuery, response.StatusCode, response.Body, scope, key)
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey forgets key within scope, so that a retry runs the request again.
+func (s *SQLStore) ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error {
+	tableName := utils.TableInstance.IdempotencyKeys
+	deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE scope = $1 AND idempotency_key = $2`, tableName)
+	_, err := s.db.ExecContext(ctx, deleteQuery, scope, key)
+	if err != nil {
+		return fmt.Errorf("❌ Error deleting %s row.\n %s", tableName, err)
+	}
+	return nil
+}
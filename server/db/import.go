@@ -0,0 +1,421 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"server/utils"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidImport is returned when the imported rows fail validation. Nothing is imported,
+// the report lists the errors.
+var ErrInvalidImport = errors.New("invalid import")
+
+// ImportRecord is an export record read from line Line of an import.
+type ImportRecord struct {
+	Line int
+	ExportRecord
+}
+
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// ImportReport describes what an import changed, or would change on a dry run. Counter rows are
+// ignored, only the history is imported, and rows whose counter_id is already present are
+// skipped, so importing an export of the same database changes nothing.
+type ImportReport struct {
+	DryRun          bool           `json:"dry_run"`
+	Inserted        map[string]int `json:"inserted"`
+	AlreadyPresent  int            `json:"already_present"`
+	IgnoredCounters int            `json:"ignored_counters"`
+	Errors          []ImportError  `json:"errors"`
+}
+
+// ImportPayload is recorded with import events, so that replays keep the imported rows.
+type ImportPayload struct {
+	Rows []ImportedRow `json:"rows"`
+}
+
+// ImportedRow is a historical row created by an import, created when its period ended.
+type ImportedRow struct {
+	Table     string  `json:"table"`
+	CounterID string  `json:"counter_id"`
+	Value     int     `json:"value"`
+	EndedAt   string  `json:"ended_at"`
+	StartedAt *string `json:"started_at"`
+}
+
+// ParseExportCSV reads records in the CSV export layout. Rows that cannot be parsed are
+// reported as import errors, a missing or unexpected header fails the whole import.
+func ParseExportCSV(r io.Reader) ([]ImportRecord, []ImportError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(ExportCSVHeader)
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, fmt.Errorf("%w: missing header", ErrInvalidImport)
+	}
+	if err != nil {
+		return nil, nil, importReadError(err)
+	}
+	if !slices.Equal(header, ExportCSVHeader) {
+		return nil, nil, fmt.Errorf("%w: expected the header %v, got %v", ErrInvalidImport, ExportCSVHeader, header)
+	}
+
+	var records []ImportRecord
+	var importErrors []ImportError
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) && parseErr.Err == csv.ErrFieldCount {
+				importErrors = append(importErrors, ImportError{Line: line, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, importReadError(err)
+		}
+
+		record, err := parseCSVRow(row)
+		if err != nil {
+			importErrors = append(importErrors, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+		records = append(records, ImportRecord{Line: line, ExportRecord: record})
+	}
+	return records, importErrors, nil
+}
+
+// importReadError tells malformed CSV, an invalid import, from failures to read it.
+func importReadError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return fmt.Errorf("%w: %s", ErrInvalidImport, err)
+	}
+	return fmt.Errorf("❌ Error reading import.\n %w", err)
+}
+
+func parseCSVRow(row []string) (ExportRecord, error) {
+	version, err := strconv.Atoi(row[0])
+	if err != nil || version != ExportVersion {
+		return ExportRecord{}, fmt.Errorf("unsupported version %q, expected %d", row[0], ExportVersion)
+	}
+	value, err := strconv.Atoi(row[3])
+	if err != nil {
+		return ExportRecord{}, fmt.Errorf("value must be an integer. Received: %q", row[3])
+	}
+	optional := func(value string) *string {
+		if value == "" {
+			return nil
+		}
+		return &value
+	}
+	record := ExportRecord{
+		Table:     row[1],
+		CounterID: optional(row[2]),
+		Value:     value,
+		CreatedAt: optional(row[5]),
+		UpdatedAt: row[6],
+		ResetedAt: optional(row[7]),
+		StartedAt: optional(row[8]),
+		EndedAt:   optional(row[9]),
+	}
+	if row[4] != "" {
+		maxValue, err := strconv.Atoi(row[4])
+		if err != nil {
+			return ExportRecord{}, fmt.Errorf("max_value must be an integer. Received: %q", row[4])
+		}
+		record.MaxValue = &maxValue
+	}
+	return record, nil
+}
+
+// ImportCSV parses the CSV and imports it into the store. Rows that cannot be parsed fail the
+// import like rows that fail validation, the report lists both. The report is nil when the
+// CSV could not be read at all.
+func ImportCSV(ctx context.Context, store CounterStore, r io.Reader, dryRun bool) (*ImportReport, error) {
+	records, parseErrors, err := ParseExportCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Still validate the parsed rows, without writing them, to report every error at once
+	report, err := store.ImportHistorical(ctx, records, dryRun || len(parseErrors) > 0)
+	if err != nil && !errors.Is(err, ErrInvalidImport) {
+		return nil, err
+	}
+	if len(parseErrors) > 0 {
+		report.DryRun = dryRun
+		report.Errors = append(report.Errors, parseErrors...)
+		sort.SliceStable(report.Errors, func(i, j int) bool {
+			return report.Errors[i].Line < report.Errors[j].Line
+		})
+		err = ErrInvalidImport
+	}
+	return &report, err
+}
+
+// importPeriod is a historical period, imported or already present, checked for overlaps.
+// Periods with an unknown start only cover their end.
+type importPeriod struct {
+	line      int
+	tableName string
+	counterId string
+	startedAt time.Time
+	endedAt   time.Time
+}
+
+func (p importPeriod) describe() string {
+	if p.line == 0 {
+		return fmt.Sprintf("the existing row %s in %s", p.counterId, p.tableName)
+	}
+	return fmt.Sprintf("line %d in %s", p.line, p.tableName)
+}
+
+// planImport validates the records against the rows already present and returns the rows to
+// insert. Healthy and ill periods follow each other, so periods must not overlap across both
+// historical tables. It only reads existing.
+func planImport(existing map[string][]HistoricalCounter, records []ImportRecord, now time.Time) ([]ImportedRow, ImportReport) {
+	report := ImportReport{Inserted: map[string]int{}, Errors: []ImportError{}}
+	var periods []importPeriod
+	present := map[string]bool{}
+	for tableName, rows := range existing {
+		report.Inserted[tableName] = 0
+		for _, row := range rows {
+			present[row.CounterID] = true
+			period, err := existingPeriod(tableName, row)
+			if err != nil {
+				continue
+			}
+			periods = append(periods, period)
+		}
+	}
+
+	var imported []ImportedRow
+	seen := map[string]int{}
+	fail := func(record ImportRecord, format string, args ...any) {
+		report.Errors = append(report.Errors, ImportError{Line: record.Line, Message: fmt.Sprintf(format, args...)})
+	}
+	for _, record := range records {
+		if record.Table == utils.TableInstance.Counter || record.Table == utils.TableInstance.OhnoCounter {
+			report.IgnoredCounters++
+			continue
+		}
+		if _, ok := existing[record.Table]; !ok {
+			fail(record, "unknown table %q", record.Table)
+			continue
+		}
+
+		counterId := uuid.New().String()
+		if record.CounterID != nil {
+			parsed, err := uuid.Parse(*record.CounterID)
+			if err != nil {
+				fail(record, "counter_id must be a UUID. Received: %q", *record.CounterID)
+				continue
+			}
+			counterId = parsed.String()
+		}
+		if present[counterId] {
+			report.AlreadyPresent++
+			continue
+		}
+		if line, ok := seen[counterId]; ok {
+			fail(record, "counter_id %s is already used on line %d", counterId, line)
+			continue
+		}
+		seen[counterId] = record.Line
+
+		if record.Value <= 0 {
+			fail(record, "value must be greater than 0. Received: %d", record.Value)
+			continue
+		}
+
+		endedAtValue := record.EndedAt
+		if endedAtValue == nil {
+			endedAtValue = record.CreatedAt
+		}
+		if endedAtValue == nil {
+			fail(record, "ended_at is required")
+			continue
+		}
+		endedAt, err := time.Parse(time.RFC3339Nano, *endedAtValue)
+		if err != nil {
+			fail(record, "ended_at must be an RFC 3339 timestamp. Received: %q", *endedAtValue)
+			continue
+		}
+		if endedAt.After(now) {
+			fail(record, "ended_at %s is in the future", *endedAtValue)
+			continue
+		}
+		period := importPeriod{line: record.Line, tableName: record.Table, counterId: counterId, startedAt: endedAt, endedAt: endedAt}
+
+		var startedAt *string
+		if record.StartedAt != nil {
+			parsed, err := time.Parse(time.RFC3339Nano, *record.StartedAt)
+			if err != nil {
+				fail(record, "started_at must be an RFC 3339 timestamp. Received: %q", *record.StartedAt)
+				continue
+			}
+			if !parsed.Before(endedAt) {
+				fail(record, "started_at %s must be before ended_at %s", *record.StartedAt, *endedAtValue)
+				continue
+			}
+			period.startedAt = parsed
+			formatted := formatSQLTimestamp(parsed)
+			startedAt = &formatted
+		}
+
+		periods = append(periods, period)
+		imported = append(imported, ImportedRow{
+			Table:     record.Table,
+			CounterID: counterId,
+			Value:     record.Value,
+			EndedAt:   formatSQLTimestamp(endedAt),
+			StartedAt: startedAt,
+		})
+		report.Inserted[record.Table]++
+	}
+
+	for _, overlap := range overlappingPeriods(periods) {
+		report.Errors = append(report.Errors, ImportError{
+			Line:    overlap[0].line,
+			Message: fmt.Sprintf("period overlaps %s", overlap[1].describe()),
+		})
+	}
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	return imported, report
+}
+
+func existingPeriod(tableName string, row HistoricalCounter) (importPeriod, error) {
+	endedAtValue := row.CreatedAt
+	if row.EndedAt != nil {
+		endedAtValue = *row.EndedAt
+	}
+	endedAt, err := parseTimestamp(endedAtValue)
+	if err != nil {
+		return importPeriod{}, err
+	}
+	period := importPeriod{tableName: tableName, counterId: row.CounterID, startedAt: endedAt, endedAt: endedAt}
+	if row.StartedAt != nil {
+		startedAt, err := parseTimestamp(*row.StartedAt)
+		if err != nil {
+			return importPeriod{}, err
+		}
+		period.startedAt = startedAt
+	}
+	return period, nil
+}
+
+// overlappingPeriods returns pairs of overlapping periods, the imported one first. Periods
+// may touch, and overlaps between rows already present are not reported.
+func overlappingPeriods(periods []importPeriod) [][2]importPeriod {
+	sorted := append([]importPeriod(nil), periods...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].startedAt.Before(sorted[j].startedAt)
+	})
+
+	var overlaps [][2]importPeriod
+	var latest *importPeriod
+	for i := range sorted {
+		period := sorted[i]
+		if latest != nil && period.startedAt.Before(latest.endedAt) && latest.startedAt.Before(period.endedAt) && (period.line != 0 || latest.line != 0) {
+			if period.line != 0 {
+				overlaps = append(overlaps, [2]importPeriod{period, *latest})
+			} else {
+				overlaps = append(overlaps, [2]importPeriod{*latest, period})
+			}
+		}
+		if latest == nil || period.endedAt.After(latest.endedAt) {
+			latest = &sorted[i]
+		}
+	}
+	return overlaps
+}
+
+func (s *SQLStore) ImportHistorical(ctx context.Context, records []ImportRecord, dryRun bool) (ImportReport, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil || dryRun {
+			tx.Rollback()
+		}
+	}()
+
+	// Serialize with transitions, which lock the state row first as well
+	_, err = s.lockState(tx)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	existing := map[string][]HistoricalCounter{}
+	for _, tableName := range []string{utils.TableInstance.HistoricalCounter, utils.TableInstance.HistoricalOhnoCounter} {
+		query := fmt.Sprintf(`
+			SELECT
+				counter_id, created_at, updated_at, started_at, ended_at, value
+			FROM %s
+		`, tableName)
+		var rows *sql.Rows
+		rows, err = tx.Query(query)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+		}
+		existing[tableName], err = scanHistoricalCounters(rows)
+		if err != nil {
+			return ImportReport{}, err
+		}
+	}
+
+	imported, report := planImport(existing, records, time.Now().UTC())
+	report.DryRun = dryRun
+	if len(report.Errors) > 0 {
+		err = ErrInvalidImport
+		return report, err
+	}
+	if dryRun || len(imported) == 0 {
+		return report, nil
+	}
+
+	for _, row := range imported {
+		insertQuery := fmt.Sprintf(`
+			INSERT INTO %s (counter_id, value, created_at, updated_at, started_at, ended_at)
+			VALUES ($1, $2, $3, $3, $4, $3)
+		`, row.Table)
+		_, err = tx.Exec(insertQuery, row.CounterID, row.Value, row.EndedAt, row.StartedAt)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("❌ Error inserting new %s row.\n %s", row.Table, err)
+		}
+	}
+
+	err = s.appendEvent(tx, EventImport, ImportPayload{Rows: imported})
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	return report, nil
+}
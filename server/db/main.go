@@ -3,53 +3,119 @@ package db
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
-	"server/db/models"
-	"server/utils"
+	"server/config"
+	"strconv"
+	"strings"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
-	"github.com/joho/godotenv"
+	_ "modernc.org/sqlite"
 )
 
-var db *sql.DB
+// sqliteOptions make write transactions take the database lock right away and wait for each
+// other instead of failing with SQLITE_BUSY.
+const sqliteOptions = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
 
-func Connect() {
-	e := godotenv.Overload("../.env")
-	if e != nil {
-		log.Printf("❌ Error loading .env file.\n %s", e)
+// NewStore returns the CounterStore selected by db_driver. SQLite suits single machine
+// deployments, the in-memory store is meant for demos and pgx is Postgres. Pending migrations
+// are applied before the store is returned.
+func NewStore(cfg *config.Config) CounterStore {
+	switch cfg.DBDriver {
+	case "memory":
+		slog.Warn("⚠️ Using in-memory store, nothing will be persisted")
+		store := NewMemoryStore()
+		store.updateInterval = cfg.UpdateInterval()
+		return store
+	case "sqlite":
+		migrateOrExit(cfg)
+		return ConnectSQLite(cfg)
+	default:
+		migrateOrExit(cfg)
+		return Connect(cfg)
 	}
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbPort := os.Getenv("DB_PORT")
-	dbHost := os.Getenv("DB_HOST")
-	dbAddress := fmt.Sprintf("%s:%s", dbHost, dbPort)
-	dbDriver := os.Getenv("DB_DRIVER")
+}
+
+func migrateOrExit(cfg *config.Config) {
+	err := migrateUp(migrationsURL(cfg))
+	if err != nil {
+		slog.Error("❌ Error migrating database.", "error", err)
+		os.Exit(1)
+	}
+}
+
+// OpenMigrator returns a Migrator for the configured database.
+func OpenMigrator(cfg *config.Config) (*Migrator, error) {
+	if cfg.DBDriver == "memory" {
+		return nil, fmt.Errorf("❌ The in-memory store has no schema to migrate")
+	}
+	return NewMigrator(migrationsURL(cfg))
+}
 
-	dbConnectionString := fmt.Sprintf("postgres://%s:%s@%s/%s", dbUser, dbPassword, dbAddress, dbName)
+func migrationsURL(cfg *config.Config) string {
+	if cfg.DBDriver == "sqlite" {
+		return fmt.Sprintf("sqlite://%s?%s", cfg.DBPath, sqliteOptions)
+	}
+	return "pgx://" + strings.TrimPrefix(postgresConnectionString(cfg), "postgres://")
+}
 
-	if dbUser == "" || dbPassword == "" || dbName == "" || dbPort == "" || dbHost == "" || dbDriver == "" {
-		log.Fatalf("❌ One or more environment variables are missing")
+func postgresConnectionString(cfg *config.Config) string {
+	connectionURL := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(cfg.DBUser, cfg.DBPassword),
+		Host:   net.JoinHostPort(cfg.DBHost, strconv.Itoa(cfg.DBPort)),
+		Path:   "/" + cfg.DBName,
 	}
+	return connectionURL.String()
+}
 
-	// Get a database handle.
-	var err error
-	db, err = sql.Open(dbDriver, dbConnectionString)
+// Connect opens the configured Postgres database and returns the store backed by it.
+func Connect(cfg *config.Config) *SQLStore {
+	db, err := sql.Open(cfg.DBDriver, postgresConnectionString(cfg))
 	if err != nil {
-		log.Printf("❌ Error getting a database handle.\n, %s", err)
+		slog.Error("❌ Error getting a database handle.", "error", err)
+		os.Exit(1)
 	}
 
 	pingErr := db.Ping()
 	if pingErr != nil {
-		log.Fatalf("❌ Error connecting to database.\n %s", pingErr)
+		slog.Error("❌ Error connecting to database.", "error", pingErr)
+		os.Exit(1)
 	}
-	log.Printf("✅ Connected to database %s on %s", dbName, dbAddress)
+	slog.Info("✅ Connected to database", "name", cfg.DBName, "host", cfg.DBHost, "port", cfg.DBPort)
 
-	models.CreateCounterTableIfNotExists(db, utils.TableInstance.Counter)
-	models.CreateOrReplaceTrigger(db, utils.TableInstance.Counter)
-	models.CreateCounterTableIfNotExists(db, utils.TableInstance.OhnoCounter)
-	models.CreateOrReplaceTrigger(db, utils.TableInstance.OhnoCounter)
-	models.CreateHistoricalCountersTableIfNotExists(db)
-	models.CreateHistoricalOhnoCountersTableIfNotExists(db)
+	store := NewPostgresStore(db)
+	store.updateInterval = cfg.UpdateInterval()
+	return store
+}
+
+// ConnectSQLite opens the configured SQLite database file and returns the store backed by it.
+func ConnectSQLite(cfg *config.Config) *SQLStore {
+	db, err := OpenSQLite(cfg.DBPath)
+	if err != nil {
+		slog.Error("❌ Error connecting to database.", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("✅ Connected to SQLite database", "path", cfg.DBPath)
+
+	store := NewSQLiteStore(db)
+	store.updateInterval = cfg.UpdateInterval()
+	return store
+}
+
+// OpenSQLite opens the SQLite database file at dbPath.
+func OpenSQLite(dbPath string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?%s", dbPath, sqliteOptions))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
 }
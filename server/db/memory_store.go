@@ -0,0 +1,900 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"server/utils"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryCounter struct {
+	currentValue int
+	maxValue     int
+	updatedAt    time.Time
+	resetedAt    *time.Time
+}
+
+// setValue mirrors an UPDATE of the counter row, including the max_value trigger.
+func (c *memoryCounter) setValue(value int, updatedAt time.Time) {
+	c.currentValue = value
+	c.updatedAt = updatedAt
+	if c.currentValue > c.maxValue {
+		c.maxValue = c.currentValue
+	}
+}
+
+// memoryIdempotencyKeyID identifies an idempotency key within the scope it was used in.
+type memoryIdempotencyKeyID struct {
+	scope string
+	key   string
+}
+
+type memoryIdempotencyKey struct {
+	requestHash string
+	response    *IdempotentResponse
+	createdAt   time.Time
+}
+
+type memoryAPIKey struct {
+	APIKey
+	keyHash string
+}
+
+type memoryHistoricalCounter struct {
+	counterId string
+	createdAt time.Time
+	updatedAt time.Time
+	startedAt *time.Time
+	endedAt   *time.Time
+	value     int
+}
+
+// MemoryStore is a CounterStore keeping everything in memory. It follows the semantics of
+// SQLStore, including the update interval check, the reset rules and the max_value
+// tracking done by the database triggers, and is meant for tests and demos.
+type MemoryStore struct {
+	mu          sync.Mutex
+	counters    map[string]*memoryCounter
+	state       State
+	changedAt   *time.Time
+	historical  map[string][]memoryHistoricalCounter
+	events      []Event
+	nextEventId int64
+	keys        map[memoryIdempotencyKeyID]*memoryIdempotencyKey
+	apiKeys     []*memoryAPIKey
+	// updateInterval is the time between two increments of a counter
+	updateInterval time.Duration
+}
+
+var _ CounterStore = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		counters: map[string]*memoryCounter{
+			utils.TableInstance.Counter:     nil,
+			utils.TableInstance.OhnoCounter: nil,
+		},
+		state:          InitialState,
+		updateInterval: defaultUpdateInterval,
+		historical: map[string][]memoryHistoricalCounter{
+			utils.TableInstance.HistoricalCounter:     nil,
+			utils.TableInstance.HistoricalOhnoCounter: nil,
+		},
+		keys: map[memoryIdempotencyKeyID]*memoryIdempotencyKey{},
+	}
+	s.appendEvent(EventSnapshot, SnapshotPayload{State: InitialState}, time.Now().UTC())
+	return s
+}
+
+// snapshot returns a copy of the counter as recorded in events, nil for a nil counter.
+func (c *memoryCounter) snapshot() *SnapshotCounter {
+	if c == nil {
+		return nil
+	}
+	return &SnapshotCounter{
+		CurrentValue: c.currentValue,
+		MaxValue:     c.maxValue,
+		UpdatedAt:    formatTimestamp(c.updatedAt),
+		ResetedAt:    formatNullableTimestamp(c.resetedAt),
+	}
+}
+
+func memoryCounterFromSnapshot(counter *SnapshotCounter) (*memoryCounter, error) {
+	if counter == nil {
+		return nil, nil
+	}
+	updatedAt, err := parseTimestamp(counter.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error parsing updated_at timestamp.\n %s", err)
+	}
+	resetedAt, err := parseNullableTimestamp(counter.ResetedAt)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error parsing reseted_at timestamp.\n %s", err)
+	}
+	return &memoryCounter{
+		currentValue: counter.CurrentValue,
+		maxValue:     counter.MaxValue,
+		updatedAt:    updatedAt,
+		resetedAt:    resetedAt,
+	}, nil
+}
+
+func (s *MemoryStore) counter(tableName string) (*memoryCounter, error) {
+	counter, ok := s.counters[tableName]
+	if !ok {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n unknown table", tableName)
+	}
+	return counter, nil
+}
+
+func (s *MemoryStore) appendEvent(kind EventKind, payload any, now time.Time) error {
+	return s.appendEventAt(kind, payload, now, now)
+}
+
+func (s *MemoryStore) appendEventAt(kind EventKind, payload any, occurredAt time.Time, now time.Time) error {
+	payloadJson, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("❌ Error marshaling %s event payload.\n %s", kind, err)
+	}
+	s.nextEventId++
+	s.events = append(s.events, Event{
+		EventID:    s.nextEventId,
+		Kind:       kind,
+		OccurredAt: formatTimestamp(occurredAt),
+		CreatedAt:  formatTimestamp(now),
+		Payload:    payloadJson,
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetCounter(ctx context.Context, tableName string) (Counter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, err := s.counter(tableName)
+	if err != nil {
+		return Counter{}, err
+	}
+	isLocked := s.state.ActiveCounter() != tableName
+
+	if counter == nil {
+		return Counter{IsLocked: isLocked}, nil
+	}
+
+	result := Counter{
+		CurrentValue: counter.currentValue,
+		MaxValue:     counter.maxValue,
+		UpdatedAt:    formatTimestamp(counter.updatedAt),
+		IsLocked:     isLocked,
+	}
+	if counter.resetedAt != nil {
+		result.ResetedAt.String = formatTimestamp(*counter.resetedAt)
+		result.ResetedAt.Valid = true
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetState(ctx context.Context) (HealthState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	healthState := HealthState{State: s.state}
+	if s.changedAt != nil {
+		healthState.ChangedAt.String = formatTimestamp(*s.changedAt)
+		healthState.ChangedAt.Valid = true
+	}
+	return healthState, nil
+}
+
+// GetHistoricalCounters returns every row of the historical table, oldest first.
+func (s *MemoryStore) GetHistoricalCounters(ctx context.Context, tableName string) ([]HistoricalCounter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.sortedHistorical(tableName, SortCreatedAtAsc)
+	if err != nil {
+		return nil, err
+	}
+
+	var historicalCounters []HistoricalCounter
+	for _, row := range rows {
+		historicalCounters = append(historicalCounters, row.historicalCounter())
+	}
+	return historicalCounters, nil
+}
+
+// ListHistoricalCounters returns a page of the historical table matching the query.
+func (s *MemoryStore) ListHistoricalCounters(ctx context.Context, tableName string, query HistoricalQuery) (HistoricalPage, error) {
+	err := query.normalize()
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+	cursor, err := query.cursor()
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows, err := s.sortedHistorical(tableName, query.Sort)
+	if err != nil {
+		return HistoricalPage{}, err
+	}
+
+	var after *memoryHistoricalCounter
+	if cursor != nil {
+		createdAt, err := parseTimestamp(cursor.CreatedAt)
+		if err != nil {
+			return HistoricalPage{}, fmt.Errorf("%w: %s", ErrInvalidCursor, err)
+		}
+		after = &memoryHistoricalCounter{counterId: cursor.CounterID, createdAt: createdAt, value: cursor.Value}
+	}
+
+	var historicalCounters []HistoricalCounter
+	for _, row := range rows {
+		if query.From != nil && row.createdAt.Before(*query.From) {
+			continue
+		}
+		if query.To != nil && !row.createdAt.Before(*query.To) {
+			continue
+		}
+		if query.MinValue != nil && row.value < *query.MinValue {
+			continue
+		}
+		if query.MaxValue != nil && row.value > *query.MaxValue {
+			continue
+		}
+		if after != nil && !historicalLess(query.Sort, *after, row) {
+			continue
+		}
+		historicalCounters = append(historicalCounters, row.historicalCounter())
+		if len(historicalCounters) > query.Limit {
+			break
+		}
+	}
+	return query.page(historicalCounters)
+}
+
+// sortedHistorical returns a copy of the rows of the historical table in the given order.
+func (s *MemoryStore) sortedHistorical(tableName string, order HistoricalSort) ([]memoryHistoricalCounter, error) {
+	rows, ok := s.historical[tableName]
+	if !ok {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n unknown table", tableName)
+	}
+	sorted := append([]memoryHistoricalCounter(nil), rows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return historicalLess(order, sorted[i], sorted[j])
+	})
+	return sorted, nil
+}
+
+// historicalLess reports whether a comes before b in the given order, mirroring the ORDER BY
+// of SQLStore.
+func historicalLess(order HistoricalSort, a, b memoryHistoricalCounter) bool {
+	if order.descending() {
+		a, b = b, a
+	}
+	if order.byValue() {
+		if a.value != b.value {
+			return a.value < b.value
+		}
+	} else if !a.createdAt.Equal(b.createdAt) {
+		return a.createdAt.Before(b.createdAt)
+	}
+	return a.counterId < b.counterId
+}
+
+func (row memoryHistoricalCounter) historicalCounter() HistoricalCounter {
+	return HistoricalCounter{
+		CounterID: row.counterId,
+		CreatedAt: formatTimestamp(row.createdAt),
+		UpdatedAt: formatTimestamp(row.updatedAt),
+		StartedAt: formatNullableTimestamp(row.startedAt),
+		EndedAt:   formatNullableTimestamp(row.endedAt),
+		Value:     row.value,
+	}
+}
+
+func (s *MemoryStore) ImportHistorical(ctx context.Context, records []ImportRecord, dryRun bool) (ImportReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := map[string][]HistoricalCounter{}
+	for tableName, rows := range s.historical {
+		existing[tableName] = []HistoricalCounter{}
+		for _, row := range rows {
+			existing[tableName] = append(existing[tableName], row.historicalCounter())
+		}
+	}
+
+	now := time.Now().UTC()
+	imported, report := planImport(existing, records, now)
+	report.DryRun = dryRun
+	if len(report.Errors) > 0 {
+		return report, ErrInvalidImport
+	}
+	if dryRun || len(imported) == 0 {
+		return report, nil
+	}
+
+	for _, row := range imported {
+		endedAt, err := parseTimestamp(row.EndedAt)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("❌ Error parsing ended_at timestamp.\n %s", err)
+		}
+		startedAt, err := parseNullableTimestamp(row.StartedAt)
+		if err != nil {
+			return ImportReport{}, fmt.Errorf("❌ Error parsing started_at timestamp.\n %s", err)
+		}
+		s.historical[row.Table] = append(s.historical[row.Table], memoryHistoricalCounter{
+			counterId: row.CounterID,
+			createdAt: endedAt,
+			updatedAt: endedAt,
+			startedAt: startedAt,
+			endedAt:   &endedAt,
+			value:     row.Value,
+		})
+	}
+	return report, s.appendEvent(EventImport, ImportPayload{Rows: imported}, now)
+}
+
+// Export copies the rows under the lock, so that they form a snapshot, and passes them to fn
+// once the lock is released.
+func (s *MemoryStore) Export(ctx context.Context, fn func(ExportRecord) error) error {
+	s.mu.Lock()
+	var records []ExportRecord
+	for _, tableName := range ExportTables() {
+		if isCounterTable(tableName) {
+			counter := s.counters[tableName]
+			if counter == nil {
+				continue
+			}
+			maxValue := counter.maxValue
+			records = append(records, ExportRecord{
+				Table:     tableName,
+				Value:     counter.currentValue,
+				MaxValue:  &maxValue,
+				UpdatedAt: formatTimestamp(counter.updatedAt),
+				ResetedAt: formatNullableTimestamp(counter.resetedAt),
+			})
+			continue
+		}
+		rows, err := s.sortedHistorical(tableName, SortCreatedAtAsc)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		for _, row := range rows {
+			historicalCounter := row.historicalCounter()
+			records = append(records, ExportRecord{
+				Table:     tableName,
+				CounterID: &historicalCounter.CounterID,
+				Value:     historicalCounter.Value,
+				CreatedAt: &historicalCounter.CreatedAt,
+				UpdatedAt: historicalCounter.UpdatedAt,
+				StartedAt: historicalCounter.StartedAt,
+				EndedAt:   historicalCounter.EndedAt,
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, record := range records {
+		err := fn(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetEvents(ctx context.Context) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []Event
+	events = append(events, s.events...)
+	return events, nil
+}
+
+func (s *MemoryStore) GetLatestStateEvents(ctx context.Context, limit int) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []Event
+	for i := len(s.events) - 1; i >= 0 && len(events) < limit; i-- {
+		if slices.Contains(stateEventKinds, s.events[i].Kind) {
+			events = append(events, s.events[i])
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) upsertCounterData(ctx context.Context, tableName string) (bool, error) {
+	if tableName == "" {
+		return false, fmt.Errorf("❌ Error upserting counter data. Table name cannot be empty.")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, err := s.counter(tableName)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+
+	if counter == nil {
+		slog.InfoContext(ctx, "No rows found in counter table. Inserting new row.", "table", tableName)
+		s.counters[tableName] = &memoryCounter{currentValue: 1, maxValue: 1, updatedAt: now}
+		return true, s.appendEvent(EventTick, TickPayload{Table: tableName, Value: 1}, now)
+	}
+
+	// A reset leaves the counter at 1 as of reseted_at, which is also its updated_at. The tick
+	// below counts from there, nothing is written until it happens.
+	lastUpdated := counter.updatedAt
+	if time.Since(lastUpdated) < s.updateInterval {
+		slog.InfoContext(ctx, "🙅 Not enough time has passed since the last update. Counter not increased...", "table", tableName, "update_interval", s.updateInterval)
+		return false, nil
+	}
+
+	counter.setValue(counter.currentValue+1, now)
+	return true, s.appendEvent(EventTick, TickPayload{Table: tableName, Value: counter.currentValue}, now)
+}
+
+func (s *MemoryStore) UpdateCounter(ctx context.Context) (bool, error) {
+	isUpdated, err := s.upsertCounterData(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		return false, err
+	}
+
+	if !isUpdated {
+		slog.InfoContext(ctx, "❌ Counter not incremented. Conditions not met.")
+	}
+
+	return isUpdated, nil
+}
+
+func (s *MemoryStore) UpdateOhnoCounter(ctx context.Context) (bool, error) {
+	isUpdated, err := s.upsertCounterData(ctx, utils.TableInstance.OhnoCounter)
+	if err != nil {
+		return false, err
+	}
+
+	if !isUpdated {
+		slog.InfoContext(ctx, "❌ Counter not incremented. Conditions not met.")
+	}
+
+	return isUpdated, nil
+}
+
+func (s *MemoryStore) SetCounter(ctx context.Context, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	tableName := utils.TableInstance.Counter
+	counter := s.counters[tableName]
+	if counter == nil {
+		s.counters[tableName] = &memoryCounter{currentValue: value, updatedAt: now}
+	} else {
+		counter.setValue(value, now)
+	}
+	return s.appendEvent(EventManualSet, ManualSetPayload{Value: value}, now)
+}
+
+func (s *MemoryStore) ResetCounter(ctx context.Context, tableName string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.resetCounter(ctx, tableName, time.Now().UTC())
+}
+
+func (s *MemoryStore) resetCounter(ctx context.Context, tableName string, now time.Time) (int, error) {
+	counter, err := s.counter(tableName)
+	if err != nil {
+		return -1, err
+	}
+
+	if counter == nil {
+		slog.WarnContext(ctx, "❌ No counter, initializing one", "table", tableName)
+		s.counters[tableName] = &memoryCounter{currentValue: 1, updatedAt: now, resetedAt: &now}
+		return 0, nil
+	}
+
+	lastValue := counter.currentValue
+	counter.setValue(1, now)
+	counter.resetedAt = &now
+	return lastValue, nil
+}
+
+func (s *MemoryStore) CreateHistoricalCounter(ctx context.Context, tableName string, lastValue int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.createHistoricalCounter(ctx, tableName, uuid.New().String(), lastValue, nil, time.Now().UTC())
+	return err
+}
+
+func (s *MemoryStore) createHistoricalCounter(ctx context.Context, tableName string, counterId string, lastValue int, startedAt *time.Time, endedAt time.Time) (string, error) {
+	if lastValue <= 0 {
+		slog.WarnContext(ctx, "❌ Error creating new historical counter. Value must be greater than 0.", "table", tableName, "value", lastValue)
+		return "", nil
+	}
+	if _, ok := s.historical[tableName]; !ok {
+		return "", fmt.Errorf("❌ Error inserting new %s row.\n unknown table", tableName)
+	}
+
+	s.historical[tableName] = append(s.historical[tableName], memoryHistoricalCounter{
+		counterId: counterId,
+		createdAt: endedAt,
+		updatedAt: endedAt,
+		startedAt: startedAt,
+		endedAt:   &endedAt,
+		value:     lastValue,
+	})
+	return counterId, nil
+}
+
+func (s *MemoryStore) TransitionTo(ctx context.Context, state State, occurredAt time.Time) error {
+	t, ok := transitions[state]
+	if !ok {
+		return fmt.Errorf("❌ Error transitioning. Unknown state: %s", state)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := s.state
+	if !current.CanTransitionTo(state) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current, state)
+	}
+
+	now := time.Now().UTC()
+	if occurredAt.IsZero() {
+		occurredAt = now
+	}
+	occurredAt = occurredAt.UTC()
+	err := validateOccurredAt(occurredAt, now, s.changedAt)
+	if err != nil {
+		return err
+	}
+
+	movedTicks, lastTickAt, err := s.ticksAfter(t.tableToReset, occurredAt)
+	if err != nil {
+		return err
+	}
+
+	undo := &TransitionUndo{
+		ChangedAt:    formatNullableTimestamp(s.changedAt),
+		ResetCounter: s.counters[t.tableToReset].snapshot(),
+	}
+
+	// The period being ended started with the previous transition
+	startedAt := s.changedAt
+	lastValue, err := s.resetCounter(ctx, t.tableToReset, occurredAt)
+	if err != nil {
+		return err
+	}
+	lastValue = max(lastValue-movedTicks, 0)
+
+	payload := TransitionPayload{Value: lastValue, MovedTicks: movedTicks, Undo: undo}
+	if movedTicks > 0 {
+		slog.InfoContext(ctx, "⏪ Moving ticks...", "ticks", movedTicks, "after", formatTimestamp(occurredAt), "from", t.tableToReset, "to", t.tableToActivate)
+		undo.ActivatedCounter = s.counters[t.tableToActivate].snapshot()
+		s.creditTicks(t.tableToActivate, movedTicks, lastTickAt)
+		payload.LastTickAt = formatTimestamp(lastTickAt)
+	}
+
+	slog.InfoContext(ctx, "🔀 Changing state...", "from", current, "to", state)
+	s.state = state
+	s.changedAt = &occurredAt
+
+	payload.CounterID, err = s.createHistoricalCounter(ctx, t.historicalTable, periodCounterID(t.historicalTable, startedAt), lastValue, startedAt, occurredAt)
+	if err != nil {
+		return err
+	}
+
+	return s.appendEventAt(t.eventKind, payload, occurredAt, now)
+}
+
+func (s *MemoryStore) UndoLastTransition(ctx context.Context, window time.Duration) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastEvent *Event
+	if len(s.events) > 0 {
+		lastEvent = &s.events[len(s.events)-1]
+	}
+	now := time.Now().UTC()
+	payload, err := undoableTransition(lastEvent, window, now)
+	if err != nil {
+		return "", err
+	}
+
+	t := transitions[transitionState(lastEvent.Kind)]
+	previous := stateBefore(lastEvent.Kind)
+
+	resetCounter, err := memoryCounterFromSnapshot(payload.Undo.ResetCounter)
+	if err != nil {
+		return "", err
+	}
+	activatedCounter := s.counters[t.tableToActivate]
+	if payload.MovedTicks > 0 {
+		activatedCounter, err = memoryCounterFromSnapshot(payload.Undo.ActivatedCounter)
+		if err != nil {
+			return "", err
+		}
+	}
+	changedAt, err := parseNullableTimestamp(payload.Undo.ChangedAt)
+	if err != nil {
+		return "", fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+	}
+
+	slog.InfoContext(ctx, "↩️ Undoing...", "kind", lastEvent.Kind, "from", s.state, "to", previous)
+	s.counters[t.tableToReset] = resetCounter
+	s.counters[t.tableToActivate] = activatedCounter
+	s.state = previous
+	s.changedAt = changedAt
+
+	rows := s.historical[t.historicalTable]
+	for i, row := range rows {
+		if row.counterId == payload.CounterID {
+			s.historical[t.historicalTable] = append(rows[:i:i], rows[i+1:]...)
+			break
+		}
+	}
+
+	err = s.appendEvent(EventUndo, UndoPayload{EventID: lastEvent.EventID}, now)
+	if err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// ticksAfter counts the tick events of the counter stored in tableName that occurred after
+// the given time and returns when the latest of them occurred.
+func (s *MemoryStore) ticksAfter(tableName string, after time.Time) (int, time.Time, error) {
+	ticks := 0
+	var lastTickAt time.Time
+	for _, event := range s.events {
+		if event.Kind != EventTick {
+			continue
+		}
+		var payload TickPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return 0, time.Time{}, fmt.Errorf("❌ Error unmarshaling event %d payload.\n %s", event.EventID, err)
+		}
+		occurredAt, err := parseTimestamp(event.OccurredAt)
+		if err != nil {
+			return 0, time.Time{}, fmt.Errorf("❌ Error parsing occurred_at timestamp.\n %s", err)
+		}
+		if payload.Table != tableName || !occurredAt.After(after) {
+			continue
+		}
+		ticks++
+		if occurredAt.After(lastTickAt) {
+			lastTickAt = occurredAt
+		}
+	}
+	return ticks, lastTickAt, nil
+}
+
+// creditTicks applies the given number of ticks to the counter stored in tableName, the
+// latest of them at lastTickAt.
+func (s *MemoryStore) creditTicks(tableName string, ticks int, lastTickAt time.Time) {
+	counter := s.counters[tableName]
+	if counter == nil {
+		s.counters[tableName] = &memoryCounter{currentValue: ticks, maxValue: ticks, updatedAt: lastTickAt}
+		return
+	}
+	counter.setValue(counter.currentValue+ticks, lastTickAt)
+}
+
+func (s *MemoryStore) RebuildFromEvents(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, err := replayEvents(s.events)
+	if err != nil {
+		return err
+	}
+
+	counters := map[string]*memoryCounter{}
+	for tableName, counter := range r.counters {
+		counters[tableName], err = memoryCounterFromSnapshot(counter)
+		if err != nil {
+			return err
+		}
+	}
+
+	changedAt, err := parseNullableTimestamp(r.changedAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+	}
+
+	since, err := parseNullableTimestamp(r.since)
+	if err != nil {
+		return fmt.Errorf("❌ Error parsing snapshot timestamp.\n %s", err)
+	}
+	replayed := map[string]bool{}
+	for _, row := range r.historical {
+		replayed[row.counterId] = true
+	}
+	historical := map[string][]memoryHistoricalCounter{}
+	for tableName, rows := range s.historical {
+		historical[tableName] = nil
+		for _, row := range rows {
+			if since != nil && row.createdAt.Before(*since) && !replayed[row.counterId] {
+				historical[tableName] = append(historical[tableName], row)
+			}
+		}
+	}
+	for _, row := range r.historical {
+		createdAt, err := parseTimestamp(row.createdAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error parsing created_at timestamp.\n %s", err)
+		}
+		startedAt, err := parseNullableTimestamp(row.startedAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error parsing started_at timestamp.\n %s", err)
+		}
+		historical[row.tableName] = append(historical[row.tableName], memoryHistoricalCounter{
+			counterId: row.counterId,
+			createdAt: createdAt,
+			updatedAt: createdAt,
+			startedAt: startedAt,
+			endedAt:   &createdAt,
+			value:     row.value,
+		})
+	}
+
+	s.counters = counters
+	s.state = r.state
+	s.changedAt = changedAt
+	s.historical = historical
+	slog.InfoContext(ctx, "✅ Rebuilt counters from events", "events", len(s.events))
+	return nil
+}
+
+func (s *MemoryStore) ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, ttl time.Duration, inFlightTimeout time.Duration) (*IdempotentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	for storedKey, stored := range s.keys {
+		abandoned := stored.response == nil && stored.createdAt.Before(now.Add(-inFlightTimeout))
+		if stored.createdAt.Before(now.Add(-ttl)) || abandoned {
+			delete(s.keys, storedKey)
+		}
+	}
+
+	id := memoryIdempotencyKeyID{scope: scope, key: key}
+	stored, ok := s.keys[id]
+	if !ok {
+		s.keys[id] = &memoryIdempotencyKey{requestHash: requestHash, createdAt: now}
+		return nil, nil
+	}
+	if stored.requestHash != requestHash {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, key)
+	}
+	if stored.response == nil {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyKeyInFlight, key)
+	}
+	response := *stored.response
+	return &response, nil
+}
+
+func (s *MemoryStore) CompleteIdempotencyKey(ctx context.Context, scope string, key string, response IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.keys[memoryIdempotencyKeyID{scope: scope, key: key}]; ok {
+		stored.response = &response
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, memoryIdempotencyKeyID{scope: scope, key: key})
+	return nil
+}
+
+func (s *MemoryStore) CreateAPIKey(ctx context.Context, name string, role Role, key string) (APIKey, error) {
+	if !role.Valid() {
+		return APIKey{}, fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyHash := hashAPIKey(key)
+	for _, stored := range s.apiKeys {
+		if stored.keyHash == keyHash {
+			return APIKey{}, fmt.Errorf("❌ Error inserting new %s row.\n duplicate key", utils.TableInstance.APIKeys)
+		}
+	}
+	apiKey := APIKey{
+		ID:        int64(len(s.apiKeys) + 1),
+		Name:      name,
+		Prefix:    apiKeyVisiblePrefix(key),
+		Role:      role,
+		CreatedAt: formatTimestamp(time.Now()),
+	}
+	s.apiKeys = append(s.apiKeys, &memoryAPIKey{APIKey: apiKey, keyHash: keyHash})
+	return apiKey, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var apiKeys []APIKey
+	for _, stored := range s.apiKeys {
+		apiKeys = append(apiKeys, stored.APIKey)
+	}
+	return apiKeys, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, stored := range s.apiKeys {
+		if stored.ID != id {
+			continue
+		}
+		if stored.RevokedAt == nil {
+			revokedAt := formatTimestamp(time.Now())
+			stored.RevokedAt = &revokedAt
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrAPIKeyNotFound, id)
+}
+
+func (s *MemoryStore) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keyHash := hashAPIKey(key)
+	for _, stored := range s.apiKeys {
+		if stored.keyHash != keyHash || stored.RevokedAt != nil {
+			continue
+		}
+		now := time.Now()
+		isStale, err := apiKeyUseIsStale(stored.APIKey, now)
+		if err != nil {
+			return APIKey{}, err
+		}
+		if isStale {
+			lastUsedAt := formatTimestamp(now)
+			stored.LastUsedAt = &lastUsedAt
+		}
+		return stored.APIKey, nil
+	}
+	return APIKey{}, ErrUnknownAPIKey
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// SchemaStatus reports an empty schema, the in-memory store has nothing to migrate.
+func (s *MemoryStore) SchemaStatus(ctx context.Context) (MigrationStatus, error) {
+	return MigrationStatus{}, nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
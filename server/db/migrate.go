@@ -0,0 +1,159 @@
+package db
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/pgx"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// migrationDirs maps the scheme of a database URL to the migrations written for it.
+var migrationDirs = map[string]string{
+	"pgx":    "migrations/postgres",
+	"sqlite": "migrations/sqlite",
+}
+
+// Migrator applies the migrations embedded in the binary. The applied version is tracked in the
+// schema_migrations table.
+type Migrator struct {
+	migrate *migrate.Migrate
+	latest  uint
+}
+
+type MigrationStatus struct {
+	Version uint `json:"version"`
+	Dirty   bool `json:"dirty"`
+	Latest  uint `json:"latest"`
+}
+
+// NewMigrator opens its own connection to the database at databaseURL, e.g. pgx://... or
+// sqlite://..., and picks the migrations matching the scheme.
+func NewMigrator(databaseURL string) (*Migrator, error) {
+	scheme, _, _ := strings.Cut(databaseURL, "://")
+	dir, ok := migrationDirs[scheme]
+	if !ok {
+		return nil, fmt.Errorf("❌ Error creating migrator. No migrations for %s databases", scheme)
+	}
+
+	migrationSource, err := iofs.New(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error reading embedded migrations.\n %s", err)
+	}
+
+	latest, err := latestVersion(migrationSource)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", migrationSource, databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error creating migrator.\n %s", err)
+	}
+	return &Migrator{migrate: m, latest: latest}, nil
+}
+
+func latestVersion(migrationSource source.Driver) (uint, error) {
+	version, err := migrationSource.First()
+	if err != nil {
+		return 0, fmt.Errorf("❌ Error reading embedded migrations.\n %s", err)
+	}
+	for {
+		next, err := migrationSource.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("❌ Error reading embedded migrations.\n %s", err)
+		}
+		version = next
+	}
+}
+
+func (m *Migrator) Status() (MigrationStatus, error) {
+	version, dirty, err := m.migrate.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return MigrationStatus{}, fmt.Errorf("❌ Error reading schema version.\n %s", err)
+	}
+	return MigrationStatus{Version: version, Dirty: dirty, Latest: m.latest}, nil
+}
+
+// Up applies all pending migrations. Databases migrated by a newer binary are left untouched.
+func (m *Migrator) Up() error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	if status.Version > status.Latest {
+		return fmt.Errorf("❌ Error migrating. Schema version %d is newer than the latest known version %d", status.Version, status.Latest)
+	}
+
+	err = m.migrate.Up()
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("❌ Error applying migrations.\n %s", err)
+	}
+	return nil
+}
+
+// Down rolls back the latest applied migration.
+func (m *Migrator) Down() error {
+	err := m.migrate.Steps(-1)
+	if err != nil {
+		return fmt.Errorf("❌ Error rolling back migration.\n %s", err)
+	}
+	return nil
+}
+
+// CheckVersion fails unless the schema is at the latest version known by this binary.
+func (m *Migrator) CheckVersion() error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+	return status.check()
+}
+
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.migrate.Close()
+	if sourceErr != nil {
+		return sourceErr
+	}
+	return dbErr
+}
+
+// migrateUp brings the schema of the database at databaseURL to the latest version and checks
+// the result.
+func migrateUp(databaseURL string) error {
+	migrator, err := NewMigrator(databaseURL)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	err = migrator.Up()
+	if err != nil {
+		return err
+	}
+
+	err = migrator.CheckVersion()
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	slog.Info("✅ Schema is up to date.", "version", status.Version)
+	return nil
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigratorUpDownStatus(t *testing.T) {
+	migrator, err := NewMigrator("sqlite://" + filepath.Join(t.TempDir(), "ohno.db"))
+	if err != nil {
+		t.Fatalf("failed to create migrator: %s", err)
+	}
+	defer migrator.Close()
+
+	status, err := migrator.Status()
+	if err != nil {
+		t.Fatalf("failed to read status: %s", err)
+	}
+	if status.Version != 0 || status.Latest == 0 {
+		t.Fatalf("expected an empty schema with pending migrations, got %+v", status)
+	}
+	if err := migrator.CheckVersion(); err == nil {
+		t.Errorf("expected version check to fail before migrating")
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("failed to migrate up: %s", err)
+	}
+	if err := migrator.CheckVersion(); err != nil {
+		t.Errorf("expected version check to pass after migrating, got %s", err)
+	}
+	// Migrating an up to date schema is a no-op
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("failed to migrate up twice: %s", err)
+	}
+
+	if err := migrator.Down(); err != nil {
+		t.Fatalf("failed to migrate down: %s", err)
+	}
+	status, err = migrator.Status()
+	if err != nil {
+		t.Fatalf("failed to read status: %s", err)
+	}
+	if status.Version != status.Latest-1 {
+		t.Errorf("expected version %d after rolling back, got %d", status.Latest-1, status.Version)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("failed to migrate up again: %s", err)
+	}
+	if err := migrator.CheckVersion(); err != nil {
+		t.Errorf("expected version check to pass after migrating again, got %s", err)
+	}
+}
+
+func TestNewMigratorUnknownScheme(t *testing.T) {
+	_, err := NewMigrator("mysql://localhost/ohno")
+	if err == nil {
+		t.Errorf("expected an error for a database without migrations")
+	}
+}
+
+func TestMigrateBackfillsHistoricalPeriods(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "ohno.db")
+	migrator, err := NewMigrator("sqlite://" + dbPath)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %s", err)
+	}
+	defer migrator.Close()
+
+	// Stop right before the historical tables got their period columns
+	if err := migrator.migrate.Migrate(6); err != nil {
+		t.Fatalf("failed to migrate to version 6: %s", err)
+	}
+
+	db, err := OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	defer db.Close()
+
+	// Timestamps are read back in RFC 3339 without fractional seconds. Periods alternate between
+	// both tables, each one starting when the previous one, in the other table, ended.
+	rows := []struct {
+		tableName string
+		counterId string
+		createdAt string
+		startedAt string
+	}{
+		{"historical_counter", "first", "2024-05-30T12:00:00Z", ""},
+		{"historical_ohno_counter", "first-ill", "2024-06-05T12:00:00Z", "2024-05-30T12:00:00Z"},
+		{"historical_counter", "second", "2024-06-10T12:00:00Z", "2024-06-05T12:00:00Z"},
+		{"historical_ohno_counter", "second-ill", "2024-06-15T12:00:00Z", "2024-06-10T12:00:00Z"},
+		{"historical_counter", "third", "2024-06-20T12:00:00Z", "2024-06-15T12:00:00Z"},
+	}
+	for _, row := range rows {
+		_, err := db.Exec(fmt.Sprintf(`INSERT INTO %s (counter_id, value, created_at, updated_at) VALUES ($1, 1, $2, $2)`, row.tableName), row.counterId, row.createdAt)
+		if err != nil {
+			t.Fatalf("failed to insert historical counter: %s", err)
+		}
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("failed to migrate up: %s", err)
+	}
+
+	for _, row := range rows {
+		var updatedAt string
+		var startedAt, endedAt sql.NullString
+		err := db.QueryRow(fmt.Sprintf(`SELECT updated_at, started_at, ended_at FROM %s WHERE counter_id = $1`, row.tableName), row.counterId).Scan(&updatedAt, &startedAt, &endedAt)
+		if err != nil {
+			t.Fatalf("failed to query historical counter: %s", err)
+		}
+		if updatedAt != row.createdAt {
+			t.Errorf("expected updated_at of %s to be kept, got %s", row.counterId, updatedAt)
+		}
+		if endedAt.String != row.createdAt {
+			t.Errorf("expected ended_at of %s to be %s, got %+v", row.counterId, row.createdAt, endedAt)
+		}
+		if row.startedAt == "" {
+			if startedAt.Valid {
+				t.Errorf("expected started_at of %s to be unknown, got %s", row.counterId, startedAt.String)
+			}
+			continue
+		}
+		if startedAt.String != row.startedAt {
+			t.Errorf("expected started_at of %s to be %s, got %+v", row.counterId, row.startedAt, startedAt)
+		}
+	}
+}
+
+func TestReadinessRequiresLatestSchema(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "ohno.db")
+	migrator, err := NewMigrator("sqlite://" + dbPath)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %s", err)
+	}
+	defer migrator.Close()
+	if err := migrator.migrate.Migrate(6); err != nil {
+		t.Fatalf("failed to migrate to version 6: %s", err)
+	}
+
+	db, err := OpenSQLite(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open database: %s", err)
+	}
+	store := NewSQLiteStore(db)
+	defer store.Close()
+
+	readiness := CheckReadiness(ctx, store)
+	if readiness.Ready || len(readiness.Checks) != 3 || !readiness.Checks[0].OK || readiness.Checks[1].OK {
+		t.Errorf("expected the schema check to fail on an outdated schema, got %+v", readiness)
+	}
+
+	if err := migrator.Up(); err != nil {
+		t.Fatalf("failed to migrate up: %s", err)
+	}
+	readiness = CheckReadiness(ctx, store)
+	if !readiness.Ready {
+		t.Errorf("expected to be ready once migrated, got %+v", readiness)
+	}
+
+	store.Close()
+	readiness = CheckReadiness(ctx, store)
+	if readiness.Ready || len(readiness.Checks) != 1 || readiness.Checks[0].Name != "database" {
+		t.Errorf("expected only the database check to fail once closed, got %+v", readiness)
+	}
+}
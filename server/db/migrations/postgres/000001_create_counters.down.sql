@@ -0,0 +1,13 @@
+-- Drop is_locked column which is bool default false to counter table
+ALTER TABLE counter DROP COLUMN IF EXISTS is_locked;
+
+-- Drop counter tables together with their triggers
+DROP TABLE IF EXISTS historical_ohno_counter;
+DROP TABLE IF EXISTS historical_counter;
+DROP TABLE IF EXISTS ohno_counter;
+DROP TABLE IF EXISTS counter;
+
+DROP FUNCTION IF EXISTS update_historical_ohno_updated_at_column();
+DROP FUNCTION IF EXISTS update_historical_updated_at_column();
+DROP FUNCTION IF EXISTS ohno_counter_update();
+DROP FUNCTION IF EXISTS counter_update();
@@ -0,0 +1,93 @@
+-- Create counter tables, databases created before migrations existed already have them
+CREATE TABLE IF NOT EXISTS counter (
+    current_value INT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reseted_at TIMESTAMP NULL DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ohno_counter (
+    current_value INT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reseted_at TIMESTAMP NULL DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS historical_counter (
+    counter_id UUID PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    value INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS historical_ohno_counter (
+    counter_id UUID PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    value INT NOT NULL
+);
+
+-- Keep updated_at and max_value of the counters up to date
+CREATE OR REPLACE FUNCTION counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ohno_counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS counter_update ON counter;
+CREATE TRIGGER counter_update
+BEFORE UPDATE ON counter
+FOR EACH ROW
+EXECUTE FUNCTION counter_update();
+
+DROP TRIGGER IF EXISTS ohno_counter_update ON ohno_counter;
+CREATE TRIGGER ohno_counter_update
+BEFORE UPDATE ON ohno_counter
+FOR EACH ROW
+EXECUTE FUNCTION ohno_counter_update();
+
+-- Keep updated_at of the historical counters up to date
+CREATE OR REPLACE FUNCTION update_historical_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION update_historical_ohno_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_historical_updated_at ON historical_counter;
+CREATE TRIGGER update_historical_updated_at
+BEFORE UPDATE ON historical_counter
+FOR EACH ROW
+EXECUTE FUNCTION update_historical_updated_at_column();
+
+DROP TRIGGER IF EXISTS update_historical_ohno_updated_at ON historical_ohno_counter;
+CREATE TRIGGER update_historical_ohno_updated_at
+BEFORE UPDATE ON historical_ohno_counter
+FOR EACH ROW
+EXECUTE FUNCTION update_historical_ohno_updated_at_column();
+
+-- Add is_locked column which is bool default false to counter table
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS is_locked BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Add max_value column which is int default 0 to counter table
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS max_value Int DEFAULT 0;
@@ -0,0 +1,10 @@
+-- Add is_locked columns back to counter and ohno_counter tables
+ALTER TABLE counter ADD COLUMN IF NOT EXISTS is_locked BOOLEAN DEFAULT FALSE;
+ALTER TABLE ohno_counter ADD COLUMN IF NOT EXISTS is_locked BOOLEAN DEFAULT FALSE;
+
+-- Restore is_locked from the persisted state
+UPDATE counter SET is_locked = COALESCE((SELECT state = 'ill' FROM health_state LIMIT 1), FALSE);
+UPDATE ohno_counter SET is_locked = COALESCE((SELECT state = 'healthy' FROM health_state LIMIT 1), TRUE);
+
+-- Drop health_state table
+DROP TABLE IF EXISTS health_state;
@@ -0,0 +1,20 @@
+-- Create health_state table holding the single current state
+CREATE TABLE IF NOT EXISTS health_state (
+    id INT PRIMARY KEY NOT NULL DEFAULT 1 CHECK (id = 1),
+    state TEXT NOT NULL CHECK (state IN ('healthy', 'ill')),
+    changed_at TIMESTAMP NULL DEFAULT NULL
+);
+
+-- Seed the state from is_locked, the counter is locked while being ill
+INSERT INTO health_state (id, state, changed_at)
+SELECT
+    1,
+    CASE WHEN c.is_locked THEN 'ill' ELSE 'healthy' END,
+    CASE WHEN c.is_locked THEN c.reseted_at ELSE (SELECT reseted_at FROM ohno_counter LIMIT 1) END
+FROM counter c
+LIMIT 1
+ON CONFLICT (id) DO NOTHING;
+
+-- Drop is_locked columns, the state is persisted in health_state only
+ALTER TABLE counter DROP COLUMN IF EXISTS is_locked;
+ALTER TABLE ohno_counter DROP COLUMN IF EXISTS is_locked;
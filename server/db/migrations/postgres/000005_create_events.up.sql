@@ -0,0 +1,20 @@
+-- Create append-only events table recording every ohno, fine, tick and manual set
+CREATE TABLE IF NOT EXISTS events (
+    event_id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set')),
+    occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    payload JSONB NOT NULL DEFAULT '{}'
+);
+
+-- Snapshot the current counters and state, replaying the log starts from it
+INSERT INTO events (kind, payload)
+SELECT
+    'snapshot',
+    jsonb_build_object(
+        'state', COALESCE((SELECT state FROM health_state LIMIT 1), 'healthy'),
+        'changed_at', (SELECT changed_at FROM health_state LIMIT 1),
+        'counter', (SELECT to_jsonb(c) FROM counter c LIMIT 1),
+        'ohno_counter', (SELECT to_jsonb(c) FROM ohno_counter c LIMIT 1)
+    )
+WHERE NOT EXISTS (SELECT 1 FROM events);
@@ -0,0 +1,22 @@
+-- Always set updated_at to now() on update
+CREATE OR REPLACE FUNCTION counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ohno_counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
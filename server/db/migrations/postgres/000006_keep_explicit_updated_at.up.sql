@@ -0,0 +1,27 @@
+-- Keep updated_at values set explicitly, e.g. by backdated transitions, and default to now()
+-- only when the update leaves updated_at untouched
+CREATE OR REPLACE FUNCTION counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at THEN
+        NEW.updated_at = now();
+    END IF;
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION ohno_counter_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at THEN
+        NEW.updated_at = now();
+    END IF;
+    IF NEW.current_value > NEW.max_value THEN
+        NEW.max_value = NEW.current_value;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
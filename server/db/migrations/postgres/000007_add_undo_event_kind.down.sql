@@ -0,0 +1,4 @@
+-- Undo events cannot be represented without the undo kind, drop them
+DELETE FROM events WHERE kind = 'undo';
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_kind_check;
+ALTER TABLE events ADD CONSTRAINT events_kind_check CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set'));
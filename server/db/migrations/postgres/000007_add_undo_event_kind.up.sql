@@ -0,0 +1,3 @@
+-- Allow undo events, recorded when a transition is undone
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_kind_check;
+ALTER TABLE events ADD CONSTRAINT events_kind_check CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set', 'undo'));
@@ -0,0 +1,8 @@
+-- Create idempotency_keys table holding the response of each request sent with an Idempotency-Key
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY NOT NULL,
+    route TEXT NOT NULL,
+    status_code INT NULL DEFAULT NULL,
+    response_body TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
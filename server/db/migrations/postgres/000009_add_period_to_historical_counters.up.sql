@@ -0,0 +1,33 @@
+-- Add the start and end of the period each historical row covers
+ALTER TABLE historical_counter ADD COLUMN IF NOT EXISTS started_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_counter ADD COLUMN IF NOT EXISTS ended_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_ohno_counter ADD COLUMN IF NOT EXISTS started_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_ohno_counter ADD COLUMN IF NOT EXISTS ended_at TIMESTAMP NULL DEFAULT NULL;
+
+-- Backfill existing rows. Each row was created when its period ended, and its period started
+-- when the period before it ended, that is when the latest earlier row of the other table was
+-- created. Rows with no earlier row in the other table keep an unknown start. The triggers are
+-- disabled so that updated_at is left as it is.
+ALTER TABLE historical_counter DISABLE TRIGGER update_historical_updated_at;
+UPDATE historical_counter h
+SET
+    ended_at = h.created_at,
+    started_at = (
+        SELECT MAX(p.created_at)
+        FROM historical_ohno_counter p
+        WHERE p.created_at < h.created_at
+    )
+WHERE h.ended_at IS NULL;
+ALTER TABLE historical_counter ENABLE TRIGGER update_historical_updated_at;
+
+ALTER TABLE historical_ohno_counter DISABLE TRIGGER update_historical_ohno_updated_at;
+UPDATE historical_ohno_counter h
+SET
+    ended_at = h.created_at,
+    started_at = (
+        SELECT MAX(p.created_at)
+        FROM historical_counter p
+        WHERE p.created_at < h.created_at
+    )
+WHERE h.ended_at IS NULL;
+ALTER TABLE historical_ohno_counter ENABLE TRIGGER update_historical_ohno_updated_at;
@@ -0,0 +1,4 @@
+-- Import events cannot be represented without the import kind, drop them
+DELETE FROM events WHERE kind = 'import';
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_kind_check;
+ALTER TABLE events ADD CONSTRAINT events_kind_check CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set', 'undo'));
@@ -0,0 +1,3 @@
+-- Allow import events, recorded when historical rows are imported
+ALTER TABLE events DROP CONSTRAINT IF EXISTS events_kind_check;
+ALTER TABLE events ADD CONSTRAINT events_kind_check CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set', 'undo', 'import'));
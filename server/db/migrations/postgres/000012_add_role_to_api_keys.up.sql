@@ -0,0 +1,2 @@
+-- Add the role of each API key, keys created before roles keep full access
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'admin' CHECK (role IN ('viewer', 'recorder', 'admin'));
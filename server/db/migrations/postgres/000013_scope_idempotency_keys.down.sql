@@ -0,0 +1,9 @@
+-- Restore the unscoped idempotency_keys table, stored responses are dropped
+DROP TABLE IF EXISTS idempotency_keys;
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT PRIMARY KEY NOT NULL,
+    route TEXT NOT NULL,
+    status_code INT NULL DEFAULT NULL,
+    response_body TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,12 @@
+-- Scope idempotency keys to the API key of the caller and remember the request they were used
+-- for. Stored responses are short-lived, they are dropped rather than migrated.
+DROP TABLE IF EXISTS idempotency_keys;
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    request_hash TEXT NOT NULL,
+    status_code INT NULL DEFAULT NULL,
+    response_body TEXT NULL DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (scope, idempotency_key)
+);
@@ -0,0 +1,5 @@
+-- Drop counter tables together with their triggers
+DROP TABLE IF EXISTS historical_ohno_counter;
+DROP TABLE IF EXISTS historical_counter;
+DROP TABLE IF EXISTS ohno_counter;
+DROP TABLE IF EXISTS counter;
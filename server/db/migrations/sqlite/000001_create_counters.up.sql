@@ -0,0 +1,67 @@
+-- Create counter tables, timestamps are stored as RFC 3339 text in UTC
+CREATE TABLE IF NOT EXISTS counter (
+    current_value INT NOT NULL,
+    max_value INT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    reseted_at TIMESTAMP NULL DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS ohno_counter (
+    current_value INT NOT NULL,
+    max_value INT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    reseted_at TIMESTAMP NULL DEFAULT NULL
+);
+
+CREATE TABLE IF NOT EXISTS historical_counter (
+    counter_id TEXT PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    value INT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS historical_ohno_counter (
+    counter_id TEXT PRIMARY KEY NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    value INT NOT NULL
+);
+
+-- Keep updated_at and max_value of the counters up to date. SQLite cannot modify NEW in a
+-- trigger, so the row is updated after the fact.
+CREATE TRIGGER IF NOT EXISTS counter_update
+AFTER UPDATE ON counter
+FOR EACH ROW
+BEGIN
+    UPDATE counter
+    SET
+        updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS ohno_counter_update
+AFTER UPDATE ON ohno_counter
+FOR EACH ROW
+BEGIN
+    UPDATE ohno_counter
+    SET
+        updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
+
+-- Keep updated_at of the historical counters up to date
+CREATE TRIGGER IF NOT EXISTS update_historical_updated_at
+AFTER UPDATE ON historical_counter
+FOR EACH ROW
+BEGIN
+    UPDATE historical_counter SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS update_historical_ohno_updated_at
+AFTER UPDATE ON historical_ohno_counter
+FOR EACH ROW
+BEGIN
+    UPDATE historical_ohno_counter SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,2 @@
+-- Drop health_state table
+DROP TABLE IF EXISTS health_state;
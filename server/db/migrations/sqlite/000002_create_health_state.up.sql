@@ -0,0 +1,6 @@
+-- Create health_state table holding the single current state
+CREATE TABLE IF NOT EXISTS health_state (
+    id INT PRIMARY KEY NOT NULL DEFAULT 1 CHECK (id = 1),
+    state TEXT NOT NULL CHECK (state IN ('healthy', 'ill')),
+    changed_at TIMESTAMP NULL DEFAULT NULL
+);
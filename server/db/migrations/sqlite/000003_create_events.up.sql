@@ -0,0 +1,28 @@
+-- Create append-only events table recording every ohno, fine, tick and manual set
+CREATE TABLE IF NOT EXISTS events (
+    event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set')),
+    occurred_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    payload TEXT NOT NULL DEFAULT '{}'
+);
+
+-- Snapshot the current counters and state, replaying the log starts from it
+INSERT INTO events (kind, payload)
+SELECT
+    'snapshot',
+    json_object(
+        'state', COALESCE((SELECT state FROM health_state LIMIT 1), 'healthy'),
+        'changed_at', (SELECT changed_at FROM health_state LIMIT 1),
+        'counter', json((
+            SELECT json_object('current_value', current_value, 'max_value', max_value, 'updated_at', updated_at, 'reseted_at', reseted_at)
+            FROM counter
+            LIMIT 1
+        )),
+        'ohno_counter', json((
+            SELECT json_object('current_value', current_value, 'max_value', max_value, 'updated_at', updated_at, 'reseted_at', reseted_at)
+            FROM ohno_counter
+            LIMIT 1
+        ))
+    )
+WHERE NOT EXISTS (SELECT 1 FROM events);
@@ -0,0 +1,24 @@
+-- Always set updated_at to now on update
+DROP TRIGGER IF EXISTS counter_update;
+CREATE TRIGGER counter_update
+AFTER UPDATE ON counter
+FOR EACH ROW
+BEGIN
+    UPDATE counter
+    SET
+        updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
+
+DROP TRIGGER IF EXISTS ohno_counter_update;
+CREATE TRIGGER ohno_counter_update
+AFTER UPDATE ON ohno_counter
+FOR EACH ROW
+BEGIN
+    UPDATE ohno_counter
+    SET
+        updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
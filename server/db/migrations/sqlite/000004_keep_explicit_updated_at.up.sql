@@ -0,0 +1,25 @@
+-- Keep updated_at values set explicitly, e.g. by backdated transitions, and default to now
+-- only when the update leaves updated_at untouched
+DROP TRIGGER IF EXISTS counter_update;
+CREATE TRIGGER counter_update
+AFTER UPDATE ON counter
+FOR EACH ROW
+BEGIN
+    UPDATE counter
+    SET
+        updated_at = CASE WHEN NEW.updated_at IS OLD.updated_at THEN strftime('%Y-%m-%dT%H:%M:%fZ', 'now') ELSE NEW.updated_at END,
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
+
+DROP TRIGGER IF EXISTS ohno_counter_update;
+CREATE TRIGGER ohno_counter_update
+AFTER UPDATE ON ohno_counter
+FOR EACH ROW
+BEGIN
+    UPDATE ohno_counter
+    SET
+        updated_at = CASE WHEN NEW.updated_at IS OLD.updated_at THEN strftime('%Y-%m-%dT%H:%M:%fZ', 'now') ELSE NEW.updated_at END,
+        max_value = CASE WHEN NEW.current_value > NEW.max_value THEN NEW.current_value ELSE NEW.max_value END
+    WHERE rowid = NEW.rowid;
+END;
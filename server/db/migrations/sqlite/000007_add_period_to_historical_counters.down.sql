@@ -0,0 +1,5 @@
+-- Drop the period columns of the historical tables
+ALTER TABLE historical_counter DROP COLUMN started_at;
+ALTER TABLE historical_counter DROP COLUMN ended_at;
+ALTER TABLE historical_ohno_counter DROP COLUMN started_at;
+ALTER TABLE historical_ohno_counter DROP COLUMN ended_at;
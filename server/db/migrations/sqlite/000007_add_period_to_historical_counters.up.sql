@@ -0,0 +1,43 @@
+-- Add the start and end of the period each historical row covers
+ALTER TABLE historical_counter ADD COLUMN started_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_counter ADD COLUMN ended_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_ohno_counter ADD COLUMN started_at TIMESTAMP NULL DEFAULT NULL;
+ALTER TABLE historical_ohno_counter ADD COLUMN ended_at TIMESTAMP NULL DEFAULT NULL;
+
+-- Backfill existing rows. Each row was created when its period ended, and its period started
+-- when the period before it ended, that is when the latest earlier row of the other table was
+-- created. Rows with no earlier row in the other table keep an unknown start. The triggers are
+-- dropped meanwhile so that updated_at is left as it is.
+DROP TRIGGER IF EXISTS update_historical_updated_at;
+UPDATE historical_counter
+SET
+    ended_at = created_at,
+    started_at = (
+        SELECT MAX(p.created_at)
+        FROM historical_ohno_counter p
+        WHERE julianday(p.created_at) < julianday(historical_counter.created_at)
+    )
+WHERE ended_at IS NULL;
+CREATE TRIGGER update_historical_updated_at
+AFTER UPDATE ON historical_counter
+FOR EACH ROW
+BEGIN
+    UPDATE historical_counter SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE rowid = NEW.rowid;
+END;
+
+DROP TRIGGER IF EXISTS update_historical_ohno_updated_at;
+UPDATE historical_ohno_counter
+SET
+    ended_at = created_at,
+    started_at = (
+        SELECT MAX(p.created_at)
+        FROM historical_counter p
+        WHERE julianday(p.created_at) < julianday(historical_ohno_counter.created_at)
+    )
+WHERE ended_at IS NULL;
+CREATE TRIGGER update_historical_ohno_updated_at
+AFTER UPDATE ON historical_ohno_counter
+FOR EACH ROW
+BEGIN
+    UPDATE historical_ohno_counter SET updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now') WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,16 @@
+-- Allow import events, recorded when historical rows are imported. SQLite cannot alter a
+-- CHECK constraint, so the table is rebuilt
+CREATE TABLE events_new (
+    event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL CHECK (kind IN ('snapshot', 'ohno', 'fine', 'tick', 'manual_set', 'undo', 'import')),
+    occurred_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ', 'now')),
+    payload TEXT NOT NULL DEFAULT '{}'
+);
+
+INSERT INTO events_new (event_id, kind, occurred_at, created_at, payload)
+SELECT event_id, kind, occurred_at, created_at, payload
+FROM events;
+
+DROP TABLE events;
+ALTER TABLE events_new RENAME TO events;
@@ -0,0 +1,2 @@
+-- Drop the role of the API keys
+ALTER TABLE api_keys DROP COLUMN role;
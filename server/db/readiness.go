@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// ReadinessCheck is the outcome of one of the checks run by CheckReadiness.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Readiness tells whether the store can serve requests, Ready only when every check passed.
+type Readiness struct {
+	Ready  bool             `json:"ready"`
+	Checks []ReadinessCheck `json:"checks"`
+}
+
+// CheckReadiness pings the database, then checks that the schema is at the latest version and
+// that the state agrees with the latest transition. Checks after a failed ping are skipped.
+// Every check reads a bounded number of rows, replaying the whole event log is left to
+// CheckReplay.
+func CheckReadiness(ctx context.Context, store CounterStore) Readiness {
+	readiness := Readiness{Ready: true}
+	check := func(name string, message string, err error) {
+		readinessCheck := ReadinessCheck{Name: name, OK: err == nil, Message: message}
+		if err != nil {
+			readinessCheck.Message = err.Error()
+			readiness.Ready = false
+		}
+		readiness.Checks = append(readiness.Checks, readinessCheck)
+	}
+
+	err := store.Ping(ctx)
+	check("database", "reachable", err)
+	if err != nil {
+		return readiness
+	}
+
+	status, err := store.SchemaStatus(ctx)
+	if err == nil {
+		err = status.check()
+	}
+	check("schema", fmt.Sprintf("version %d of %d", status.Version, status.Latest), err)
+
+	healthState, err := checkState(ctx, store)
+	check("state", fmt.Sprintf("%s, matching the latest transition", healthState.State), err)
+	return readiness
+}
+
+// check fails unless the schema is at the latest version.
+func (status MigrationStatus) check() error {
+	if status.Dirty {
+		return fmt.Errorf("❌ Schema version %d is dirty, a migration failed half way and needs fixing by hand", status.Version)
+	}
+	if status.Version != status.Latest {
+		return fmt.Errorf("❌ Schema version %d does not match the expected version %d", status.Version, status.Latest)
+	}
+	return nil
+}
+
+// checkState fails unless the state is known and matches the state set by the latest snapshot,
+// transition or undo in the event log.
+func checkState(ctx context.Context, store CounterStore) (HealthState, error) {
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		return healthState, err
+	}
+	if !healthState.State.Valid() {
+		return healthState, fmt.Errorf("❌ Unknown state: %s", healthState.State)
+	}
+
+	// An undo directly follows the transition it undoes, which tells the state restored
+	events, err := store.GetLatestStateEvents(ctx, 2)
+	if err != nil {
+		return healthState, err
+	}
+	expected, err := latestState(events)
+	if err != nil {
+		return healthState, err
+	}
+	if expected != healthState.State {
+		return healthState, fmt.Errorf("❌ State is %s but the latest transition leads to %s, rebuild the counters", healthState.State, expected)
+	}
+	return healthState, nil
+}
+
+// latestState returns the state set by events, the latest state events the newest first.
+func latestState(events []Event) (State, error) {
+	if len(events) == 0 {
+		return InitialState, nil
+	}
+
+	latest := events[0]
+	switch latest.Kind {
+	case EventSnapshot:
+		var payload SnapshotPayload
+		if err := json.Unmarshal(latest.Payload, &payload); err != nil {
+			return "", fmt.Errorf("❌ Error unmarshaling event %d payload.\n %s", latest.EventID, err)
+		}
+		if payload.State == "" {
+			return InitialState, nil
+		}
+		return payload.State, nil
+	case EventOhno, EventFine:
+		return transitionState(latest.Kind), nil
+	case EventUndo:
+		var payload UndoPayload
+		if err := json.Unmarshal(latest.Payload, &payload); err != nil {
+			return "", fmt.Errorf("❌ Error unmarshaling event %d payload.\n %s", latest.EventID, err)
+		}
+		if len(events) < 2 || events[1].EventID != payload.EventID {
+			return "", fmt.Errorf("❌ Undone event %d does not precede undo event %d", payload.EventID, latest.EventID)
+		}
+		return stateBefore(events[1].Kind), nil
+	default:
+		return "", fmt.Errorf("❌ Event %d of kind %s does not set the state", latest.EventID, latest.Kind)
+	}
+}
+
+// CheckReplay fails unless the state matches the state the whole event log leads to, which is
+// what a rebuild would restore. It reads every event, so it is not part of CheckReadiness.
+func CheckReplay(ctx context.Context, store CounterStore) error {
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		return err
+	}
+	events, err := store.GetEvents(ctx)
+	if err != nil {
+		return err
+	}
+	r, err := replayEvents(events)
+	if err != nil {
+		return err
+	}
+	if r.state != healthState.State {
+		return fmt.Errorf("❌ State is %s but the events lead to %s, rebuild the counters", healthState.State, r.state)
+	}
+	return nil
+}
+
+func (s *SQLStore) Ping(ctx context.Context) error {
+	err := s.db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("❌ Error connecting to database.\n %s", err)
+	}
+	return nil
+}
+
+// SchemaStatus reads the version recorded in schema_migrations by the migrator.
+func (s *SQLStore) SchemaStatus(ctx context.Context) (MigrationStatus, error) {
+	migrationSource, err := iofs.New(migrationFiles, s.dialect.migrations)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("❌ Error reading embedded migrations.\n %s", err)
+	}
+	defer migrationSource.Close()
+
+	status := MigrationStatus{}
+	status.Latest, err = latestVersion(migrationSource)
+	if err != nil {
+		return MigrationStatus{}, err
+	}
+
+	var version int64
+	err = s.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &status.Dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("❌ Error reading schema version.\n %s", err)
+	}
+	status.Version = uint(version)
+	return status, nil
+}
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"server/utils"
+
+	"github.com/google/uuid"
+)
+
+type replayedHistoricalCounter struct {
+	tableName string
+	counterId string
+	value     int
+	createdAt string
+	startedAt *string
+}
+
+// replay holds the counters, state and historical rows recomputed from the event log.
+type replay struct {
+	// since is the created_at of the snapshot the replay started from. Historical rows created
+	// before it are not covered by the log. Nil when the log has no snapshot.
+	since      *string
+	state      State
+	changedAt  *string
+	counters   map[string]*SnapshotCounter
+	historical []replayedHistoricalCounter
+	// checkpoints hold the replay as it was before each transition, for undo events.
+	checkpoints map[int64]replayCheckpoint
+}
+
+type replayCheckpoint struct {
+	state      State
+	changedAt  *string
+	counters   map[string]*SnapshotCounter
+	historical int
+}
+
+func (r *replay) checkpoint() replayCheckpoint {
+	counters := map[string]*SnapshotCounter{}
+	for tableName, counter := range r.counters {
+		if counter != nil {
+			copied := *counter
+			counter = &copied
+		}
+		counters[tableName] = counter
+	}
+	return replayCheckpoint{state: r.state, changedAt: r.changedAt, counters: counters, historical: len(r.historical)}
+}
+
+func newReplay() *replay {
+	return &replay{
+		state: InitialState,
+		counters: map[string]*SnapshotCounter{
+			utils.TableInstance.Counter:     nil,
+			utils.TableInstance.OhnoCounter: nil,
+		},
+		checkpoints: map[int64]replayCheckpoint{},
+	}
+}
+
+// replayEvents recomputes the counters, state and historical rows by applying the events,
+// starting from the latest snapshot.
+func replayEvents(events []Event) (*replay, error) {
+	start := 0
+	for i, event := range events {
+		if event.Kind == EventSnapshot {
+			start = i
+		}
+	}
+
+	r := newReplay()
+	for _, event := range events[start:] {
+		err := r.apply(event)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error replaying event %d.\n %s", event.EventID, err)
+		}
+	}
+	return r, nil
+}
+
+func (r *replay) apply(event Event) error {
+	switch event.Kind {
+	case EventSnapshot:
+		var payload SnapshotPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		since := event.CreatedAt
+		r.since = &since
+		r.state = payload.State
+		if r.state == "" {
+			r.state = InitialState
+		}
+		r.changedAt = payload.ChangedAt
+		r.counters[utils.TableInstance.Counter] = payload.Counter
+		r.counters[utils.TableInstance.OhnoCounter] = payload.OhnoCounter
+
+	case EventTick:
+		var payload TickPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		counter, ok := r.counters[payload.Table]
+		if !ok {
+			return fmt.Errorf("unknown counter table: %s", payload.Table)
+		}
+		if counter == nil {
+			r.counters[payload.Table] = &SnapshotCounter{CurrentValue: payload.Value, MaxValue: payload.Value, UpdatedAt: event.OccurredAt}
+			return nil
+		}
+		r.setValue(counter, payload.Value, event.OccurredAt)
+
+	case EventManualSet:
+		var payload ManualSetPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		counter := r.counters[utils.TableInstance.Counter]
+		if counter == nil {
+			r.counters[utils.TableInstance.Counter] = &SnapshotCounter{CurrentValue: payload.Value, UpdatedAt: event.OccurredAt}
+			return nil
+		}
+		r.setValue(counter, payload.Value, event.OccurredAt)
+
+	case EventOhno, EventFine:
+		var payload TransitionPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		next := transitionState(event.Kind)
+		t := transitions[next]
+		r.checkpoints[event.EventID] = r.checkpoint()
+
+		lastValue := 0
+		startedAt := r.changedAt
+		counter := r.counters[t.tableToReset]
+		occurredAt := event.OccurredAt
+		if counter == nil {
+			r.counters[t.tableToReset] = &SnapshotCounter{CurrentValue: 1, UpdatedAt: occurredAt, ResetedAt: &occurredAt}
+		} else {
+			lastValue = max(counter.CurrentValue-payload.MovedTicks, 0)
+			r.setValue(counter, 1, occurredAt)
+			counter.ResetedAt = &occurredAt
+		}
+
+		if payload.MovedTicks > 0 {
+			activated := r.counters[t.tableToActivate]
+			if activated == nil {
+				r.counters[t.tableToActivate] = &SnapshotCounter{CurrentValue: payload.MovedTicks, MaxValue: payload.MovedTicks, UpdatedAt: payload.LastTickAt}
+			} else {
+				r.setValue(activated, activated.CurrentValue+payload.MovedTicks, payload.LastTickAt)
+			}
+		}
+
+		r.state = next
+		r.changedAt = &occurredAt
+
+		if lastValue > 0 {
+			counterId := payload.CounterID
+			if counterId == "" {
+				counterId = uuid.New().String()
+			}
+			r.historical = append(r.historical, replayedHistoricalCounter{
+				tableName: t.historicalTable,
+				counterId: counterId,
+				value:     lastValue,
+				createdAt: occurredAt,
+				startedAt: startedAt,
+			})
+		}
+
+	case EventImport:
+		var payload ImportPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		for _, row := range payload.Rows {
+			r.historical = append(r.historical, replayedHistoricalCounter{
+				tableName: row.Table,
+				counterId: row.CounterID,
+				value:     row.Value,
+				createdAt: row.EndedAt,
+				startedAt: row.StartedAt,
+			})
+		}
+
+	case EventUndo:
+		var payload UndoPayload
+		if err := json.Unmarshal(event.Payload, &payload); err != nil {
+			return err
+		}
+		cp, ok := r.checkpoints[payload.EventID]
+		if !ok {
+			return fmt.Errorf("undone event %d is not covered by the replay", payload.EventID)
+		}
+		r.state = cp.state
+		r.changedAt = cp.changedAt
+		r.counters = cp.counters
+		r.historical = r.historical[:cp.historical]
+
+	default:
+		return fmt.Errorf("unknown event kind: %s", event.Kind)
+	}
+	return nil
+}
+
+// setValue mirrors an UPDATE of the counter row, including the max_value trigger.
+func (r *replay) setValue(counter *SnapshotCounter, value int, updatedAt string) {
+	counter.CurrentValue = value
+	counter.UpdatedAt = updatedAt
+	if counter.CurrentValue > counter.MaxValue {
+		counter.MaxValue = counter.CurrentValue
+	}
+}
+
+// RebuildFromEvents recomputes the counters, the state and the historical rows by replaying
+// the event log, repairing whatever got out of sync with it. Historical rows created before
+// the snapshot the replay starts from are left untouched.
+func (s *SQLStore) RebuildFromEvents(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Serialize with transitions, which lock the state row first as well
+	_, err = s.lockState(tx)
+	if err != nil {
+		return err
+	}
+
+	eventsQuery := fmt.Sprintf(`
+		SELECT
+			event_id, kind, occurred_at, created_at, payload
+		FROM %s
+		ORDER BY event_id
+	`, utils.TableInstance.Events)
+
+	rows, err := tx.Query(eventsQuery)
+	if err != nil {
+		return fmt.Errorf("❌ Error querying %s table.\n %s", utils.TableInstance.Events, err)
+	}
+	events, err := scanEvents(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	r, err := replayEvents(events)
+	if err != nil {
+		return err
+	}
+
+	for _, tableName := range []string{utils.TableInstance.Counter, utils.TableInstance.OhnoCounter} {
+		_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s`, tableName))
+		if err != nil {
+			return fmt.Errorf("❌ Error deleting %s rows.\n %s", tableName, err)
+		}
+
+		counter := r.counters[tableName]
+		if counter == nil {
+			continue
+		}
+		insertQuery := fmt.Sprintf(`
+			INSERT INTO %s (current_value, max_value, updated_at, reseted_at)
+			VALUES ($1, $2, $3, $4)
+		`, tableName)
+		_, err = tx.Exec(insertQuery, counter.CurrentValue, counter.MaxValue, counter.UpdatedAt, counter.ResetedAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+		}
+	}
+
+	stateQuery := fmt.Sprintf(`
+		INSERT INTO %s (id, state, changed_at)
+		VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE
+		SET state = EXCLUDED.state, changed_at = EXCLUDED.changed_at
+	`, utils.TableInstance.HealthState)
+	_, err = tx.Exec(stateQuery, string(r.state), r.changedAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", utils.TableInstance.HealthState, err)
+	}
+
+	for _, tableName := range []string{utils.TableInstance.HistoricalCounter, utils.TableInstance.HistoricalOhnoCounter} {
+		if r.since == nil {
+			_, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s`, tableName))
+		} else {
+			deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE %s >= %s`, tableName, s.dialect.timestamp("created_at"), s.dialect.timestamp("$1"))
+			_, err = tx.Exec(deleteQuery, *r.since)
+		}
+		if err != nil {
+			return fmt.Errorf("❌ Error deleting %s rows.\n %s", tableName, err)
+		}
+	}
+
+	for _, historicalCounter := range r.historical {
+		// Backdated rows may predate the snapshot and survive the delete above
+		deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE counter_id = $1`, historicalCounter.tableName)
+		_, err = tx.Exec(deleteQuery, historicalCounter.counterId)
+		if err != nil {
+			return fmt.Errorf("❌ Error deleting %s rows.\n %s", historicalCounter.tableName, err)
+		}
+
+		insertQuery := fmt.Sprintf(`
+			INSERT INTO %s (counter_id, value, created_at, updated_at, started_at, ended_at)
+			VALUES ($1, $2, $3, $3, $4, $3)
+		`, historicalCounter.tableName)
+		_, err = tx.Exec(insertQuery, historicalCounter.counterId, historicalCounter.value, historicalCounter.createdAt, historicalCounter.startedAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error inserting new %s row.\n %s", historicalCounter.tableName, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	slog.InfoContext(ctx, "✅ Rebuilt counters from events", "events", len(events))
+	return nil
+}
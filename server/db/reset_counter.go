@@ -1,49 +1,66 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"time"
 )
 
-func ResetCounter(tableName string) (int, error) {
-	var counter Counter
-	var lastValue int
-
-	tx, err := db.Begin()
+func (s *SQLStore) ResetCounter(ctx context.Context, tableName string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return -1, fmt.Errorf("❌ Error starting transaction.\n %s", err)
 	}
 
+	lastValue, err := s.resetCounter(ctx, tx, tableName, formatSQLTimestamp(time.Now()))
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return -1, fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+
+	return lastValue, err
+}
+
+// resetCounter resets the counter stored in tableName to 1 as of resetAt within the given
+// transaction and returns the value the counter had before the reset.
+func (s *SQLStore) resetCounter(ctx context.Context, tx *sql.Tx, tableName string, resetAt string) (int, error) {
+	var counter Counter
+	var lastValue int
+
 	rawQuery := `
-		SELECT 
-			current_value, is_locked, updated_at, reseted_at 
-		FROM 
-		%s	
-		LIMIT 1 FOR UPDATE;
+		SELECT
+			current_value, updated_at, reseted_at
+		FROM
+		%s
+		LIMIT 1 %s;
 	`
 
-	query := fmt.Sprintf(rawQuery, tableName)
-	err = tx.QueryRow(query).Scan(&counter.CurrentValue, &counter.IsLocked, &counter.UpdatedAt, &counter.ResetedAt)
+	query := fmt.Sprintf(rawQuery, tableName, s.dialect.forUpdate)
+	err := tx.QueryRow(query).Scan(&counter.CurrentValue, &counter.UpdatedAt, &counter.ResetedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("❌ No %s, initializing one", tableName)
+			slog.WarnContext(ctx, "❌ No counter, initializing one", "table", tableName)
 
 			rawInsertQuery := `
-				INSERT INTO %s (current_value, is_locked, updated_at, reseted_at)
-				VALUES (1, false, NOW(), NOW());
+				INSERT INTO %s (current_value, updated_at, reseted_at)
+				VALUES (1, $1, $1);
 			`
 
 			insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
-			_, err = tx.Exec(insertQuery)
+			_, err = tx.Exec(insertQuery, resetAt)
 			if err != nil {
-				tx.Rollback()
 				return -1, fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
 			}
 
 		} else {
-			tx.Rollback()
 			return -1, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
 		}
 
@@ -52,23 +69,17 @@ func ResetCounter(tableName string) (int, error) {
 
 		rawUpdateQuery := (`
 			UPDATE
-				%s	
-			SET 
-				current_value = 1, updated_at = NOW(), reseted_at = NOW()
+				%s
+			SET
+				current_value = 1, updated_at = $1, reseted_at = $1
 			`)
 		updateQuery := fmt.Sprintf(rawUpdateQuery, tableName)
 
-		_, err = tx.Exec(updateQuery)
+		_, err = tx.Exec(updateQuery, resetAt)
 		if err != nil {
-			tx.Rollback()
 			return -1, fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return -1, fmt.Errorf("❌ Error committing transaction.\n %s", err)
-	}
-
-	return lastValue, err
+	return lastValue, nil
 }
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"server/utils"
+)
+
+type State string
+
+const (
+	Healthy State = "healthy"
+	Ill     State = "ill"
+)
+
+// InitialState is the state assumed before the first transition is recorded.
+const InitialState = Healthy
+
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// ErrInvalidOccurredAt is returned for transitions dated in the future or before the previous
+// transition.
+var ErrInvalidOccurredAt = errors.New("invalid occurred_at")
+
+var allowedTransitions = map[State][]State{
+	Healthy: {Ill},
+	Ill:     {Healthy},
+}
+
+// Valid reports whether s is one of the known states.
+func (s State) Valid() bool {
+	_, ok := allowedTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether moving from s to next is an allowed transition.
+func (s State) CanTransitionTo(next State) bool {
+	for _, allowed := range allowedTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveCounter returns the name of the counter table that is incremented in the state.
+func (s State) ActiveCounter() string {
+	if s == Ill {
+		return utils.TableInstance.OhnoCounter
+	}
+	return utils.TableInstance.Counter
+}
+
+type HealthState struct {
+	State     State
+	ChangedAt sql.NullString
+}
+
+func (s *SQLStore) GetState(ctx context.Context) (HealthState, error) {
+	var healthState HealthState
+	query := fmt.Sprintf(`
+		SELECT
+			state, changed_at
+		FROM %s
+		LIMIT 1
+	`, utils.TableInstance.HealthState)
+
+	err := s.db.QueryRowContext(ctx, query).Scan(&healthState.State, &healthState.ChangedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return HealthState{State: InitialState}, nil
+		}
+		return HealthState{}, fmt.Errorf("❌ Error querying %s table.\n %s", utils.TableInstance.HealthState, err)
+	}
+	return healthState, nil
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// healthyWindows are the rolling windows, in days, the healthy percentage is computed over.
+var healthyWindows = []int{30, 90, 365}
+
+// streakPercentiles are reported next to the mean and the median of the streaks.
+var streakPercentiles = []float64{25, 75, 90}
+
+// Stats summarizes the timeline. Streaks are healthy periods measured by their counter value.
+// Time based figures only cover periods with a known start.
+type Stats struct {
+	Streaks                 StreakStats         `json:"streaks"`
+	MeanSecondsBetweenOhnos *float64            `json:"mean_seconds_between_ohnos"`
+	IllDaysPerYear          map[string]float64  `json:"ill_days_per_year"`
+	HealthyPercentage       map[string]*float64 `json:"healthy_percentage"`
+}
+
+// StreakStats describes the finished healthy streaks. Current is the value of the open healthy
+// period, nil while ill. Longest also considers the current streak.
+type StreakStats struct {
+	Count       int                `json:"count"`
+	Mean        *float64           `json:"mean"`
+	Median      *float64           `json:"median"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	Current     *int               `json:"current"`
+	Longest     *Streak            `json:"longest"`
+}
+
+type Streak struct {
+	Value     int     `json:"value"`
+	StartedAt *string `json:"started_at"`
+	EndedAt   *string `json:"ended_at"`
+}
+
+// timedPeriod is a timeline period with a known start, open periods end at now.
+type timedPeriod struct {
+	kind      State
+	startedAt time.Time
+	endedAt   time.Time
+}
+
+// BuildStats computes the statistics of the timeline of the store as of now.
+func BuildStats(ctx context.Context, store CounterStore, now time.Time) (Stats, error) {
+	timeline, err := BuildTimeline(ctx, store, now)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var periods []timedPeriod
+	for _, period := range timeline {
+		if period.StartedAt == nil {
+			continue
+		}
+		startedAt, err := parseTimestamp(*period.StartedAt)
+		if err != nil {
+			return Stats{}, fmt.Errorf("❌ Error parsing started_at timestamp.\n %s", err)
+		}
+		endedAt := now
+		if period.EndedAt != nil {
+			endedAt, err = parseTimestamp(*period.EndedAt)
+			if err != nil {
+				return Stats{}, fmt.Errorf("❌ Error parsing ended_at timestamp.\n %s", err)
+			}
+		}
+		periods = append(periods, timedPeriod{kind: period.Kind, startedAt: startedAt, endedAt: endedAt})
+	}
+
+	ohnosAt, err := ohnoTimes(timeline)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := Stats{
+		Streaks:                 streakStats(timeline),
+		MeanSecondsBetweenOhnos: meanSecondsBetween(ohnosAt),
+		IllDaysPerYear:          illDaysPerYear(periods),
+		HealthyPercentage:       map[string]*float64{},
+	}
+	for _, days := range healthyWindows {
+		windowStart := now.Add(-time.Duration(days) * day)
+		stats.HealthyPercentage[fmt.Sprintf("%dd", days)] = healthyPercentage(periods, windowStart, now)
+	}
+	return stats, nil
+}
+
+func streakStats(timeline []TimelinePeriod) StreakStats {
+	stats := StreakStats{Percentiles: map[string]float64{}}
+	var values []float64
+	for _, period := range timeline {
+		if period.Kind != Healthy {
+			continue
+		}
+		if period.EndedAt == nil {
+			current := period.Value
+			stats.Current = &current
+		} else {
+			values = append(values, float64(period.Value))
+		}
+		if stats.Longest == nil || period.Value > stats.Longest.Value {
+			stats.Longest = &Streak{Value: period.Value, StartedAt: period.StartedAt, EndedAt: period.EndedAt}
+		}
+	}
+
+	stats.Count = len(values)
+	if len(values) == 0 {
+		return stats
+	}
+	sort.Float64s(values)
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	median := percentile(values, 50)
+	stats.Mean = &mean
+	stats.Median = &median
+	for _, p := range streakPercentiles {
+		stats.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = percentile(values, p)
+	}
+	return stats
+}
+
+// percentile interpolates linearly between the closest ranks of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// ohnoTimes returns when each ill period started, that is when each healthy period ended.
+func ohnoTimes(timeline []TimelinePeriod) ([]time.Time, error) {
+	var ohnosAt []time.Time
+	for _, period := range timeline {
+		if period.Kind != Healthy || period.EndedAt == nil {
+			continue
+		}
+		endedAt, err := parseTimestamp(*period.EndedAt)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error parsing ended_at timestamp.\n %s", err)
+		}
+		ohnosAt = append(ohnosAt, endedAt)
+	}
+	return ohnosAt, nil
+}
+
+func meanSecondsBetween(times []time.Time) *float64 {
+	if len(times) < 2 {
+		return nil
+	}
+	mean := times[len(times)-1].Sub(times[0]).Seconds() / float64(len(times)-1)
+	return &mean
+}
+
+// illDaysPerYear sums the ill time of each UTC calendar year, in days.
+func illDaysPerYear(periods []timedPeriod) map[string]float64 {
+	illDays := map[string]float64{}
+	for _, period := range periods {
+		if period.kind != Ill {
+			continue
+		}
+		for year := period.startedAt.Year(); year <= period.endedAt.Year(); year++ {
+			yearStart := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+			overlap := overlapOf(period, yearStart, yearStart.AddDate(1, 0, 0))
+			if overlap > 0 {
+				illDays[strconv.Itoa(year)] += overlap.Hours() / 24
+			}
+		}
+	}
+	return illDays
+}
+
+// healthyPercentage is the share of the known time between from and to spent healthy, nil
+// when none of it is known.
+func healthyPercentage(periods []timedPeriod, from time.Time, to time.Time) *float64 {
+	var known, healthy time.Duration
+	for _, period := range periods {
+		overlap := overlapOf(period, from, to)
+		known += overlap
+		if period.kind == Healthy {
+			healthy += overlap
+		}
+	}
+	if known <= 0 {
+		return nil
+	}
+	percentage := 100 * healthy.Seconds() / known.Seconds()
+	return &percentage
+}
+
+func overlapOf(period timedPeriod, from time.Time, to time.Time) time.Duration {
+	start := period.startedAt
+	if from.After(start) {
+		start = from
+	}
+	end := period.endedAt
+	if to.Before(end) {
+		end = to
+	}
+	return max(end.Sub(start), 0)
+}
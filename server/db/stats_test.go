@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func assertFloat(t *testing.T, field string, value *float64, expected float64) {
+	t.Helper()
+	if value == nil || math.Abs(*value-expected) > 1e-6 {
+		t.Errorf("expected %s to be %f, got %v", field, expected, value)
+	}
+}
+
+// streakTimeline returns a timeline of finished healthy periods of the given values, separated
+// by ill ones, followed by an open period in state of value current.
+func streakTimeline(values []int, state State, current int) []TimelinePeriod {
+	var timeline []TimelinePeriod
+	endedAt := "2024-01-01T00:00:00Z"
+	for _, value := range values {
+		timeline = append(timeline,
+			TimelinePeriod{Kind: Healthy, EndedAt: &endedAt, Value: value},
+			TimelinePeriod{Kind: Ill, EndedAt: &endedAt, Value: 1},
+		)
+	}
+	return append(timeline, TimelinePeriod{Kind: state, Value: current})
+}
+
+func TestStreakStats(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		timeline        []TimelinePeriod
+		expectedCount   int
+		expectedMean    *float64
+		expectedMedian  *float64
+		expectedP90     float64
+		expectedCurrent *int
+		expectedLongest *int
+		longestIsOpen   bool
+	}{
+		{
+			name:     "no streak",
+			timeline: streakTimeline(nil, Ill, 2),
+		},
+		{
+			name:            "only the current streak",
+			timeline:        streakTimeline(nil, Healthy, 3),
+			expectedCurrent: ptr(3),
+			expectedLongest: ptr(3),
+			longestIsOpen:   true,
+		},
+		{
+			name:            "finished streaks",
+			timeline:        streakTimeline([]int{10, 5}, Healthy, 4),
+			expectedCount:   2,
+			expectedMean:    ptr(7.5),
+			expectedMedian:  ptr(7.5),
+			expectedP90:     9.5,
+			expectedCurrent: ptr(4),
+			expectedLongest: ptr(10),
+		},
+		{
+			name:            "current streak is the longest",
+			timeline:        streakTimeline([]int{1, 2, 6}, Healthy, 8),
+			expectedCount:   3,
+			expectedMean:    ptr(3.0),
+			expectedMedian:  ptr(2.0),
+			expectedP90:     5.2,
+			expectedCurrent: ptr(8),
+			expectedLongest: ptr(8),
+			longestIsOpen:   true,
+		},
+		{
+			name:            "while ill",
+			timeline:        streakTimeline([]int{4}, Ill, 1),
+			expectedCount:   1,
+			expectedMean:    ptr(4.0),
+			expectedMedian:  ptr(4.0),
+			expectedP90:     4,
+			expectedLongest: ptr(4),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stats := streakStats(tc.timeline)
+			if stats.Count != tc.expectedCount {
+				t.Errorf("expected %d finished streaks, got %d", tc.expectedCount, stats.Count)
+			}
+			if tc.expectedMean == nil {
+				if stats.Mean != nil || stats.Median != nil || len(stats.Percentiles) != 0 {
+					t.Errorf("expected no mean, median nor percentiles, got %+v", stats)
+				}
+			} else {
+				assertFloat(t, "mean", stats.Mean, *tc.expectedMean)
+				assertFloat(t, "median", stats.Median, *tc.expectedMedian)
+				p90 := stats.Percentiles["p90"]
+				assertFloat(t, "p90", &p90, tc.expectedP90)
+			}
+			if (stats.Current == nil) != (tc.expectedCurrent == nil) || (stats.Current != nil && *stats.Current != *tc.expectedCurrent) {
+				t.Errorf("expected the current streak to be %v, got %v", tc.expectedCurrent, stats.Current)
+			}
+			if tc.expectedLongest == nil {
+				if stats.Longest != nil {
+					t.Errorf("expected no longest streak, got %+v", stats.Longest)
+				}
+				return
+			}
+			if stats.Longest == nil || stats.Longest.Value != *tc.expectedLongest || (stats.Longest.EndedAt == nil) != tc.longestIsOpen {
+				t.Errorf("expected the longest streak to be %d, open: %v, got %+v", *tc.expectedLongest, tc.longestIsOpen, stats.Longest)
+			}
+		})
+	}
+}
+
+func TestIllDaysPerYear(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		periods  []timedPeriod
+		expected map[string]float64
+	}{
+		{
+			name:     "healthy only",
+			periods:  []timedPeriod{{kind: Healthy, startedAt: date(2024, 1, 1), endedAt: date(2024, 2, 1)}},
+			expected: map[string]float64{},
+		},
+		{
+			name: "within a year",
+			periods: []timedPeriod{
+				{kind: Ill, startedAt: date(2024, 1, 10), endedAt: date(2024, 1, 15)},
+				{kind: Ill, startedAt: date(2024, 3, 1), endedAt: date(2024, 3, 1).Add(12 * time.Hour)},
+			},
+			expected: map[string]float64{"2024": 5.5},
+		},
+		{
+			name:     "across new year",
+			periods:  []timedPeriod{{kind: Ill, startedAt: date(2023, 12, 30), endedAt: date(2024, 1, 2)}},
+			expected: map[string]float64{"2023": 2, "2024": 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			illDays := illDaysPerYear(tc.periods)
+			if len(illDays) != len(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, illDays)
+			}
+			for year, expected := range tc.expected {
+				days := illDays[year]
+				assertFloat(t, "ill days in "+year, &days, expected)
+			}
+		})
+	}
+}
+
+func TestHealthyPercentage(t *testing.T) {
+	periods := []timedPeriod{
+		{kind: Healthy, startedAt: date(2024, 1, 1), endedAt: date(2024, 1, 8)},
+		{kind: Ill, startedAt: date(2024, 1, 8), endedAt: date(2024, 1, 9)},
+		{kind: Healthy, startedAt: date(2024, 1, 9), endedAt: date(2024, 1, 11)},
+	}
+	for _, tc := range []struct {
+		name     string
+		from     time.Time
+		to       time.Time
+		expected *float64
+	}{
+		{"whole history", date(2024, 1, 1), date(2024, 1, 11), ptr(90.0)},
+		{"window larger than the history", date(2023, 1, 1), date(2024, 1, 11), ptr(90.0)},
+		{"window within an ill period", date(2024, 1, 8), date(2024, 1, 9), ptr(0.0)},
+		{"window across the ill period", date(2024, 1, 7), date(2024, 1, 10), ptr(100 * 2.0 / 3)},
+		{"window before the history", date(2023, 1, 1), date(2023, 2, 1), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			percentage := healthyPercentage(periods, tc.from, tc.to)
+			if tc.expected == nil {
+				if percentage != nil {
+					t.Errorf("expected no percentage, got %f", *percentage)
+				}
+				return
+			}
+			assertFloat(t, "healthy_percentage", percentage, *tc.expected)
+		})
+	}
+}
+
+func TestMeanSecondsBetween(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		times    []time.Time
+		expected *float64
+	}{
+		{"none", nil, nil},
+		{"single", []time.Time{date(2024, 1, 1)}, nil},
+		{"several", []time.Time{date(2024, 1, 1), date(2024, 1, 2), date(2024, 1, 5)}, ptr((2 * day).Seconds())},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mean := meanSecondsBetween(tc.times)
+			if tc.expected == nil {
+				if mean != nil {
+					t.Errorf("expected no mean, got %f", *mean)
+				}
+				return
+			}
+			assertFloat(t, "mean", mean, *tc.expected)
+		})
+	}
+}
+
+func TestBuildStats(t *testing.T) {
+	store := timelineFixture{
+		periods: []fixturePeriod{
+			{kind: Healthy, endedAt: date(2024, 1, 10), value: 5},
+			{kind: Ill, endedAt: date(2024, 1, 15), value: 2},
+			{kind: Healthy, endedAt: date(2024, 3, 1), value: 10},
+			{kind: Ill, endedAt: date(2024, 3, 2), value: 1},
+		},
+		state:     Healthy,
+		changedAt: date(2024, 3, 2),
+		counter:   4,
+	}.store()
+
+	stats, err := BuildStats(context.Background(), store, date(2024, 3, 12))
+	if err != nil {
+		t.Fatalf("failed to build stats: %s", err)
+	}
+
+	if stats.Streaks.Count != 2 || stats.Streaks.Current == nil || *stats.Streaks.Current != 4 {
+		t.Errorf("expected 2 finished streaks and a current one of 4, got %+v", stats.Streaks)
+	}
+	longest := stats.Streaks.Longest
+	if longest == nil || longest.Value != 10 {
+		t.Fatalf("expected the longest streak to be 10, got %+v", longest)
+	}
+	assertNullableTimestamp(t, "longest started_at", longest.StartedAt, date(2024, 1, 15))
+	assertNullableTimestamp(t, "longest ended_at", longest.EndedAt, date(2024, 3, 1))
+
+	assertFloat(t, "mean_seconds_between_ohnos", stats.MeanSecondsBetweenOhnos, (51 * day).Seconds())
+
+	illDays := stats.IllDaysPerYear["2024"]
+	assertFloat(t, "ill days in 2024", &illDays, 6)
+
+	// The first healthy period has an unknown start, the windows only cover 2024-01-10 onwards
+	assertFloat(t, "healthy_percentage 30d", stats.HealthyPercentage["30d"], 100*29.0/30)
+	assertFloat(t, "healthy_percentage 90d", stats.HealthyPercentage["90d"], 100*(62.0-6)/62)
+	assertFloat(t, "healthy_percentage 365d", stats.HealthyPercentage["365d"], 100*(62.0-6)/62)
+}
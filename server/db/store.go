@@ -0,0 +1,113 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CounterStore persists the counters, the health state, the historical periods and the event
+// log. Handlers and background tasks receive a CounterStore instead of talking to a database.
+type CounterStore interface {
+	GetCounter(ctx context.Context, tableName string) (Counter, error)
+	GetState(ctx context.Context) (HealthState, error)
+	GetHistoricalCounters(ctx context.Context, tableName string) ([]HistoricalCounter, error)
+	ListHistoricalCounters(ctx context.Context, tableName string, query HistoricalQuery) (HistoricalPage, error)
+	GetEvents(ctx context.Context) ([]Event, error)
+	// GetLatestStateEvents returns the latest limit snapshot, ohno, fine and undo events, the
+	// newest first.
+	GetLatestStateEvents(ctx context.Context, limit int) ([]Event, error)
+	// UpdateCounter increments the counter once the update interval has passed since its last
+	// update. It returns false without an error when the interval has not passed yet.
+	UpdateCounter(ctx context.Context) (bool, error)
+	UpdateOhnoCounter(ctx context.Context) (bool, error)
+	SetCounter(ctx context.Context, value int) error
+	ResetCounter(ctx context.Context, tableName string) (int, error)
+	CreateHistoricalCounter(ctx context.Context, tableName string, lastValue int) error
+	// TransitionTo records the transition into state as happening at occurredAt, a zero
+	// occurredAt meaning now.
+	TransitionTo(ctx context.Context, state State, occurredAt time.Time) error
+	UndoLastTransition(ctx context.Context, window time.Duration) (State, error)
+	RebuildFromEvents(ctx context.Context) error
+	// ReserveIdempotencyKey reserves key within scope for the request identified by requestHash.
+	// It returns the stored response if the request already ran, ErrIdempotencyKeyReused if the
+	// key was used for another request.
+	ReserveIdempotencyKey(ctx context.Context, scope string, key string, requestHash string, ttl time.Duration, inFlightTimeout time.Duration) (*IdempotentResponse, error)
+	CompleteIdempotencyKey(ctx context.Context, scope string, key string, response IdempotentResponse) error
+	ReleaseIdempotencyKey(ctx context.Context, scope string, key string) error
+	CreateAPIKey(ctx context.Context, name string, role Role, key string) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+	// AuthenticateAPIKey returns the unrevoked API key matching key, ErrUnknownAPIKey if there
+	// is none.
+	AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error)
+	// ImportHistorical validates the records and inserts the historical rows among them in a
+	// single transaction. On a dry run, or when validation fails with ErrInvalidImport, nothing
+	// is written and the report tells what would have changed.
+	ImportHistorical(ctx context.Context, records []ImportRecord, dryRun bool) (ImportReport, error)
+	// Export passes every counter and historical row to fn, one at a time, as of a single
+	// snapshot. It stops at the first error returned by fn.
+	Export(ctx context.Context, fn func(ExportRecord) error) error
+	// Ping checks that the database can be reached.
+	Ping(ctx context.Context) error
+	// SchemaStatus compares the schema version of the database with the latest migration
+	// embedded in the binary.
+	SchemaStatus(ctx context.Context) (MigrationStatus, error)
+	Close() error
+}
+
+// dialect captures the SQL differences between the databases SQLStore runs on.
+type dialect struct {
+	// now is the expression returning the current timestamp.
+	now string
+	// forUpdate locks the selected rows until the transaction ends. Empty where the whole
+	// database is locked by a write transaction anyway.
+	forUpdate string
+	// timestamp wraps a timestamp column or parameter so that it compares chronologically.
+	timestamp func(expr string) string
+	// snapshot is the isolation level under which consecutive reads see the same data.
+	snapshot sql.IsolationLevel
+	// migrations is the directory of the embedded migrations written for the database.
+	migrations string
+}
+
+var postgresDialect = dialect{
+	now:        "NOW()",
+	forUpdate:  "FOR UPDATE",
+	timestamp:  func(expr string) string { return expr },
+	snapshot:   sql.LevelRepeatableRead,
+	migrations: migrationDirs["pgx"],
+}
+
+var sqliteDialect = dialect{
+	now:        "strftime('%Y-%m-%dT%H:%M:%fZ', 'now')",
+	forUpdate:  "",
+	timestamp:  func(expr string) string { return "julianday(" + expr + ")" },
+	snapshot:   sql.LevelDefault,
+	migrations: migrationDirs["sqlite"],
+}
+
+// defaultUpdateInterval is the time between two increments of a counter, unless the store is
+// created with another one.
+const defaultUpdateInterval = 24 * time.Hour
+
+// SQLStore is the CounterStore backed by a SQL database, Postgres or SQLite.
+type SQLStore struct {
+	db             *sql.DB
+	dialect        dialect
+	updateInterval time.Duration
+}
+
+var _ CounterStore = (*SQLStore)(nil)
+
+func NewPostgresStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: postgresDialect, updateInterval: defaultUpdateInterval}
+}
+
+func NewSQLiteStore(db *sql.DB) *SQLStore {
+	return &SQLStore{db: db, dialect: sqliteDialect, updateInterval: defaultUpdateInterval}
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,1522 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Conformance suite. Every scenario runs against each CounterStore backend, so the backends
+cannot drift apart.
+*/
+
+// storeBackend creates empty stores of one CounterStore implementation together with a way to
+// seed counter rows into them.
+type storeBackend struct {
+	name        string
+	newStore    func(t *testing.T) CounterStore
+	seedCounter func(t *testing.T, s CounterStore, tableName string, counter Counter)
+	// seedHistorical inserts a historical row, created and ended at CreatedAt.
+	seedHistorical func(t *testing.T, s CounterStore, tableName string, historicalCounter HistoricalCounter)
+}
+
+func storeBackends() []storeBackend {
+	return []storeBackend{
+		{
+			name: "memory",
+			newStore: func(t *testing.T) CounterStore {
+				return NewMemoryStore()
+			},
+			seedCounter:    seedMemoryCounter,
+			seedHistorical: seedMemoryHistorical,
+		},
+		{
+			name: "postgres",
+			newStore: func(t *testing.T) CounterStore {
+				requirePostgres(t)
+				cleanupAllTables(t)
+				t.Cleanup(func() { cleanupAllTables(t) })
+				return store
+			},
+			seedCounter:    seedSQLCounter,
+			seedHistorical: seedSQLHistorical,
+		},
+		{
+			name: "sqlite",
+			newStore: func(t *testing.T) CounterStore {
+				dbPath := filepath.Join(t.TempDir(), "ohno.db")
+				if err := migrateUp("sqlite://" + dbPath); err != nil {
+					t.Fatalf("failed to migrate sqlite database: %s", err)
+				}
+				sqliteDb, err := OpenSQLite(dbPath)
+				if err != nil {
+					t.Fatalf("failed to open sqlite database: %s", err)
+				}
+				sqliteStore := NewSQLiteStore(sqliteDb)
+				t.Cleanup(func() { sqliteStore.Close() })
+				return sqliteStore
+			},
+			seedCounter:    seedSQLCounter,
+			seedHistorical: seedSQLHistorical,
+		},
+	}
+}
+
+func cleanupAllTables(t *testing.T) {
+	tables := []string{
+		utils.TableInstance.Counter,
+		utils.TableInstance.OhnoCounter,
+		utils.TableInstance.HistoricalCounter,
+		utils.TableInstance.HistoricalOhnoCounter,
+		utils.TableInstance.HealthState,
+		utils.TableInstance.Events,
+		utils.TableInstance.APIKeys,
+		utils.TableInstance.IdempotencyKeys,
+	}
+	for _, tableName := range tables {
+		cleanupTable(t, tableName)
+	}
+}
+
+func seedMemoryCounter(t *testing.T, s CounterStore, tableName string, counter Counter) {
+	memoryStore := s.(*MemoryStore)
+	updatedAt, err := parseTimestamp(counter.UpdatedAt)
+	if err != nil {
+		t.Fatalf("failed to parse updated_at: %s", err)
+	}
+	seeded := &memoryCounter{currentValue: counter.CurrentValue, maxValue: counter.MaxValue, updatedAt: updatedAt}
+	if counter.ResetedAt.Valid {
+		resetedAt, err := parseTimestamp(counter.ResetedAt.String)
+		if err != nil {
+			t.Fatalf("failed to parse reseted_at: %s", err)
+		}
+		seeded.resetedAt = &resetedAt
+	}
+	memoryStore.counters[tableName] = seeded
+}
+
+func seedSQLCounter(t *testing.T, s CounterStore, tableName string, counter Counter) {
+	sqlStore := s.(*SQLStore)
+	if _, err := sqlStore.db.Exec(fmt.Sprintf(`DELETE FROM %s;`, tableName)); err != nil {
+		t.Fatalf("failed to clean up table: %s", err)
+	}
+	rawInsertQuery := `
+		INSERT INTO %s (current_value, max_value, updated_at, reseted_at)
+		VALUES ($1, $2, $3, $4);
+	`
+	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
+	_, err := sqlStore.db.Exec(insertQuery, counter.CurrentValue, counter.MaxValue, counter.UpdatedAt, counter.ResetedAt)
+	if err != nil {
+		t.Fatalf("failed to insert into table: %s, err: %s", tableName, err)
+	}
+}
+
+func seedMemoryHistorical(t *testing.T, s CounterStore, tableName string, historicalCounter HistoricalCounter) {
+	memoryStore := s.(*MemoryStore)
+	createdAt, err := parseTimestamp(historicalCounter.CreatedAt)
+	if err != nil {
+		t.Fatalf("failed to parse created_at: %s", err)
+	}
+	memoryStore.historical[tableName] = append(memoryStore.historical[tableName], memoryHistoricalCounter{
+		counterId: historicalCounter.CounterID,
+		createdAt: createdAt,
+		updatedAt: createdAt,
+		endedAt:   &createdAt,
+		value:     historicalCounter.Value,
+	})
+}
+
+func seedSQLHistorical(t *testing.T, s CounterStore, tableName string, historicalCounter HistoricalCounter) {
+	sqlStore := s.(*SQLStore)
+	rawInsertQuery := `
+		INSERT INTO %s (counter_id, value, created_at, updated_at, ended_at)
+		VALUES ($1, $2, $3, $3, $3);
+	`
+	insertQuery := fmt.Sprintf(rawInsertQuery, tableName)
+	_, err := sqlStore.db.Exec(insertQuery, historicalCounter.CounterID, historicalCounter.Value, historicalCounter.CreatedAt)
+	if err != nil {
+		t.Fatalf("failed to insert into table: %s, err: %s", tableName, err)
+	}
+}
+
+type conformanceScenario struct {
+	name string
+	run  func(t *testing.T, b storeBackend, s CounterStore)
+}
+
+var conformanceScenarios = []conformanceScenario{
+	{"EmptyCounters", scenarioEmptyCounters},
+	{"UpdateCounterNoData", scenarioUpdateCounterNoData},
+	{"UpdateCounterMaxValueTracked", scenarioUpdateCounterMaxValueTracked},
+	{"UpdateCounterMaxValueNotReached", scenarioUpdateCounterMaxValueNotReached},
+	{"UpdateCounterTimeDidNotPass", scenarioUpdateCounterTimeDidNotPass},
+	{"UpdateCounterAfterReset", scenarioUpdateCounterAfterReset},
+	{"ResetCounterNoData", scenarioResetCounterNoData},
+	{"ResetCounter", scenarioResetCounter},
+	{"CreateHistoricalCounterSkipsNonPositive", scenarioCreateHistoricalCounterSkipsNonPositive},
+	{"SetCounter", scenarioSetCounter},
+	{"TransitionToIll", scenarioTransitionToIll},
+	{"IllegalTransition", scenarioIllegalTransition},
+	{"RebuildFromEvents", scenarioRebuildFromEvents},
+	{"BackdatedTransition", scenarioBackdatedTransition},
+	{"BackdatedTransitionRejected", scenarioBackdatedTransitionRejected},
+	{"UndoLastTransition", scenarioUndoLastTransition},
+	{"UndoRefused", scenarioUndoRefused},
+	{"IdempotencyKeys", scenarioIdempotencyKeys},
+	{"APIKeys", scenarioAPIKeys},
+	{"HistoricalPeriods", scenarioHistoricalPeriods},
+	{"ListHistoricalCounters", scenarioListHistoricalCounters},
+	{"ListHistoricalCountersInvalidCursor", scenarioListHistoricalCountersInvalidCursor},
+	{"Export", scenarioExport},
+	{"ExportDoesNotBlockWrites", scenarioExportDoesNotBlockWrites},
+	{"ImportHistorical", scenarioImportHistorical},
+	{"ImportRejected", scenarioImportRejected},
+	{"CalendarUID", scenarioCalendarUID},
+	{"Readiness", scenarioReadiness},
+}
+
+func TestStoreConformance(t *testing.T) {
+	for _, b := range storeBackends() {
+		t.Run(b.name, func(t *testing.T) {
+			for _, scenario := range conformanceScenarios {
+				t.Run(scenario.name, func(t *testing.T) {
+					scenario.run(t, b, b.newStore(t))
+				})
+			}
+		})
+	}
+}
+
+func assertRecent(t *testing.T, field string, value string) {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", field, err)
+	}
+	// Allow for a small time difference (e.g., 5 seconds)
+	if time.Now().UTC().Sub(parsed).Seconds() > 5 {
+		t.Errorf("expected %s to be close to now, got '%s'", field, value)
+	}
+}
+
+func assertTimestamp(t *testing.T, field string, value string, expected time.Time) {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", field, err)
+	}
+	if parsed.Sub(expected).Abs() > time.Millisecond {
+		t.Errorf("expected %s to be %s, got '%s'", field, expected.Format(time.RFC3339Nano), value)
+	}
+}
+
+func scenarioEmptyCounters(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 0 || counter.MaxValue != 0 || counter.UpdatedAt != "" || counter.ResetedAt.Valid {
+		t.Errorf("expected an empty counter, got %+v", counter)
+	}
+	if counter.IsLocked != false {
+		t.Errorf("expected counter isLocked to be false, got %v", counter.IsLocked)
+	}
+
+	ohnoCounter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get ohno counter: %s", err)
+	}
+	if ohnoCounter.IsLocked != true {
+		t.Errorf("expected ohno counter isLocked to be true, got %v", ohnoCounter.IsLocked)
+	}
+
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Healthy {
+		t.Errorf("expected state to be %s, got %s", Healthy, healthState.State)
+	}
+}
+
+func scenarioUpdateCounterNoData(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || !isUpdated {
+		t.Errorf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if counter.MaxValue != 1 {
+		t.Errorf("expected max_value to be 1, got %d", counter.MaxValue)
+	}
+	assertRecent(t, "updated_at", counter.UpdatedAt)
+	if counter.ResetedAt.Valid {
+		t.Errorf("expected reseted_at to be null, got %v", counter.ResetedAt)
+	}
+}
+
+func scenarioUpdateCounterMaxValueTracked(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.OhnoCounter, Counter{CurrentValue: 42, MaxValue: 42, UpdatedAt: "2024-05-30T12:34:56Z"})
+
+	if isUpdated, err := s.UpdateOhnoCounter(ctx); err != nil || !isUpdated {
+		t.Errorf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 43 {
+		t.Errorf("expected current_value to be 43, got %d", counter.CurrentValue)
+	}
+	if counter.MaxValue != 43 {
+		t.Errorf("expected max_value to be 43, got %d", counter.MaxValue)
+	}
+	assertRecent(t, "updated_at", counter.UpdatedAt)
+}
+
+func scenarioUpdateCounterMaxValueNotReached(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{CurrentValue: 42, MaxValue: 100, UpdatedAt: "2024-05-30T12:34:56Z"})
+
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || !isUpdated {
+		t.Errorf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 43 {
+		t.Errorf("expected current_value to be 43, got %d", counter.CurrentValue)
+	}
+	if counter.MaxValue != 100 {
+		t.Errorf("expected max_value to be 100, got %d", counter.MaxValue)
+	}
+}
+
+func scenarioUpdateCounterTimeDidNotPass(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	updatedLessThan24hAgo := time.Now().UTC().Add(-23 * time.Hour).Format(time.RFC3339)
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{CurrentValue: 42, MaxValue: 42, UpdatedAt: updatedLessThan24hAgo})
+
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || isUpdated {
+		t.Errorf("expected isUpdated to be false, got %v (error: %v)", isUpdated, err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 42 {
+		t.Errorf("expected current_value to be 42, got %d", counter.CurrentValue)
+	}
+	if counter.UpdatedAt != updatedLessThan24hAgo {
+		t.Errorf("expected updated_at: '%s' to not change, got '%s'", updatedLessThan24hAgo, counter.UpdatedAt)
+	}
+}
+
+func scenarioUpdateCounterAfterReset(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	if err := s.SetCounter(ctx, 5); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	// The SQLite trigger takes an updated_at equal to the previous one, to the millisecond,
+	// for an untouched one and bumps it to now
+	time.Sleep(2 * time.Millisecond)
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || isUpdated {
+		t.Errorf("expected isUpdated to be false, got %v (error: %v)", isUpdated, err)
+	}
+
+	// No event records a skipped tick, updated_at stays at the reset
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	updatedAt, err := parseTimestamp(counter.UpdatedAt)
+	if err != nil {
+		t.Fatalf("failed to parse updated_at: %s", err)
+	}
+	resetedAt, err := parseNullableTimestamp(nullableString(counter.ResetedAt))
+	if err != nil {
+		t.Fatalf("failed to parse reseted_at: %s", err)
+	}
+	if counter.CurrentValue != 1 || resetedAt == nil || !updatedAt.Equal(*resetedAt) {
+		t.Errorf("expected current_value 1 and updated_at at the reset, got %+v", counter)
+	}
+}
+
+func scenarioResetCounterNoData(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	lastValue, err := s.ResetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to reset counter: %s", err)
+	}
+	if lastValue != 0 {
+		t.Errorf("expected last value to be 0, got %d", lastValue)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if !counter.ResetedAt.Valid {
+		t.Errorf("expected reseted_at to be a valid sql null string, got %v", counter.ResetedAt)
+	}
+}
+
+func scenarioResetCounter(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{
+		CurrentValue: 42,
+		MaxValue:     42,
+		UpdatedAt:    "2024-05-30T12:34:56Z",
+		ResetedAt:    sql.NullString{String: "2024-05-01T12:00:00Z", Valid: true},
+	})
+
+	lastValue, err := s.ResetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to reset counter: %s", err)
+	}
+	if lastValue != 42 {
+		t.Errorf("expected last value to be 42, got %d", lastValue)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if counter.MaxValue != 42 {
+		t.Errorf("expected max_value to be 42, got %d", counter.MaxValue)
+	}
+	assertRecent(t, "updated_at", counter.UpdatedAt)
+	assertRecent(t, "reseted_at", counter.ResetedAt.String)
+}
+
+func scenarioCreateHistoricalCounterSkipsNonPositive(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	tableName := utils.TableInstance.HistoricalCounter
+	if err := s.CreateHistoricalCounter(ctx, tableName, 0); err != nil {
+		t.Fatalf("failed to create %s: %s", tableName, err)
+	}
+	if err := s.CreateHistoricalCounter(ctx, tableName, 7); err != nil {
+		t.Fatalf("failed to create %s: %s", tableName, err)
+	}
+
+	historicalCounters, err := s.GetHistoricalCounters(ctx, tableName)
+	if err != nil {
+		t.Fatalf("failed to get %s: %s", tableName, err)
+	}
+	if len(historicalCounters) != 1 {
+		t.Fatalf("expected 1 historical counter, got %d", len(historicalCounters))
+	}
+	if historicalCounters[0].Value != 7 {
+		t.Errorf("expected value to be 7, got %d", historicalCounters[0].Value)
+	}
+	assertRecent(t, "created_at", historicalCounters[0].CreatedAt)
+}
+
+func scenarioSetCounter(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	if err := s.SetCounter(ctx, 12); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 12 {
+		t.Errorf("expected current_value to be 12, got %d", counter.CurrentValue)
+	}
+
+	events, err := s.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("failed to get events: %s", err)
+	}
+	if len(events) == 0 || events[len(events)-1].Kind != EventManualSet {
+		t.Errorf("expected the last event to be %s, got %+v", EventManualSet, events)
+	}
+}
+
+func scenarioTransitionToIll(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{CurrentValue: 42, MaxValue: 42, UpdatedAt: "2024-05-30T12:34:56Z"})
+
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if counter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+	}
+	if counter.IsLocked != true {
+		t.Errorf("expected isLocked to be true, got %v", counter.IsLocked)
+	}
+
+	ohnoCounter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get ohno counter: %s", err)
+	}
+	if ohnoCounter.IsLocked != false {
+		t.Errorf("expected isLocked to be false, got %v", ohnoCounter.IsLocked)
+	}
+
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 1 || historicalCounters[0].Value != 42 {
+		t.Errorf("expected a single historical counter with value 42, got %+v", historicalCounters)
+	}
+
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Ill {
+		t.Errorf("expected state to be %s, got %s", Ill, healthState.State)
+	}
+	assertRecent(t, "changed_at", healthState.ChangedAt.String)
+
+	events, err := s.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("failed to get events: %s", err)
+	}
+	if len(events) == 0 || events[len(events)-1].Kind != EventOhno {
+		t.Errorf("expected the last event to be %s, got %+v", EventOhno, events)
+	}
+}
+
+func scenarioIllegalTransition(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	err := s.TransitionTo(ctx, Healthy, time.Time{})
+	if !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("expected ErrIllegalTransition, got %v", err)
+	}
+
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 0 {
+		t.Errorf("expected 0 historical counters, got %d", len(historicalCounters))
+	}
+}
+
+func scenarioRebuildFromEvents(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	if err := s.SetCounter(ctx, 41); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+	if isUpdated, err := s.UpdateOhnoCounter(ctx); err != nil || !isUpdated {
+		t.Fatalf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+	if err := s.TransitionTo(ctx, Healthy, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Healthy, err)
+	}
+
+	// Corrupt the counter, it is repaired by the replay
+	b.seedCounter(t, s, utils.TableInstance.OhnoCounter, Counter{CurrentValue: 999, MaxValue: 999, UpdatedAt: "2024-05-30T12:34:56Z"})
+
+	if err := s.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+
+	ohnoCounter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get ohno counter: %s", err)
+	}
+	if ohnoCounter.CurrentValue != 1 {
+		t.Errorf("expected current_value to be 1, got %d", ohnoCounter.CurrentValue)
+	}
+
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 1 || historicalCounters[0].Value != 41 {
+		t.Errorf("expected a single historical counter with value 41, got %+v", historicalCounters)
+	}
+
+	historicalOhnoCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical ohno counters: %s", err)
+	}
+	if len(historicalOhnoCounters) != 1 || historicalOhnoCounters[0].Value != 1 {
+		t.Errorf("expected a single historical ohno counter with value 1, got %+v", historicalOhnoCounters)
+	}
+
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Healthy {
+		t.Errorf("expected state to be %s, got %s", Healthy, healthState.State)
+	}
+}
+
+func TestReplayAppliesTickValue(t *testing.T) {
+	snapshot, err := json.Marshal(SnapshotPayload{State: Healthy, Counter: &SnapshotCounter{CurrentValue: 5, MaxValue: 5, UpdatedAt: "2024-05-01T00:00:00.000Z"}})
+	if err != nil {
+		t.Fatalf("failed to encode snapshot: %s", err)
+	}
+	tick, err := json.Marshal(TickPayload{Table: utils.TableInstance.Counter, Value: 7})
+	if err != nil {
+		t.Fatalf("failed to encode tick: %s", err)
+	}
+	ohnoTick, err := json.Marshal(TickPayload{Table: utils.TableInstance.OhnoCounter, Value: 3})
+	if err != nil {
+		t.Fatalf("failed to encode tick: %s", err)
+	}
+
+	r, err := replayEvents([]Event{
+		{EventID: 1, Kind: EventSnapshot, OccurredAt: "2024-05-01T00:00:00.000Z", Payload: snapshot},
+		{EventID: 2, Kind: EventTick, OccurredAt: "2024-05-02T00:00:00.000Z", Payload: tick},
+		{EventID: 3, Kind: EventTick, OccurredAt: "2024-05-03T00:00:00.000Z", Payload: ohnoTick},
+	})
+	if err != nil {
+		t.Fatalf("failed to replay events: %s", err)
+	}
+	counter := r.counters[utils.TableInstance.Counter]
+	if counter == nil || counter.CurrentValue != 7 || counter.MaxValue != 7 || counter.UpdatedAt != "2024-05-02T00:00:00.000Z" {
+		t.Errorf("expected the counter at the recorded value 7, got %+v", counter)
+	}
+	ohnoCounter := r.counters[utils.TableInstance.OhnoCounter]
+	if ohnoCounter == nil || ohnoCounter.CurrentValue != 3 || ohnoCounter.MaxValue != 3 {
+		t.Errorf("expected the ohno counter at the recorded value 3, got %+v", ohnoCounter)
+	}
+}
+
+// setUpdateInterval changes the minimum time between two increments of the store for the test.
+func setUpdateInterval(t *testing.T, s CounterStore, updateInterval time.Duration) {
+	var field *time.Duration
+	switch store := s.(type) {
+	case *SQLStore:
+		field = &store.updateInterval
+	case *MemoryStore:
+		field = &store.updateInterval
+	default:
+		t.Fatalf("unknown store %T", s)
+	}
+	previous := *field
+	*field = updateInterval
+	t.Cleanup(func() {
+		*field = previous
+	})
+}
+
+func scenarioBackdatedTransition(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	// Let every update tick, the counter keeps ticking while nobody has recorded the ohno yet
+	setUpdateInterval(t, s, 0)
+	occurredAt := time.Now().UTC().Add(-time.Hour)
+	if err := s.SetCounter(ctx, 5); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || !isUpdated {
+		t.Fatalf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+
+	if err := s.TransitionTo(ctx, Ill, occurredAt); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	assertBackdated := func(t *testing.T) {
+		counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+		if err != nil {
+			t.Fatalf("failed to get counter: %s", err)
+		}
+		if counter.CurrentValue != 1 {
+			t.Errorf("expected current_value to be 1, got %d", counter.CurrentValue)
+		}
+		assertTimestamp(t, "reseted_at", counter.ResetedAt.String, occurredAt)
+
+		// The tick after occurredAt belongs to the ohno counter
+		ohnoCounter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+		if err != nil {
+			t.Fatalf("failed to get ohno counter: %s", err)
+		}
+		if ohnoCounter.CurrentValue != 1 {
+			t.Errorf("expected ohno current_value to be 1, got %d", ohnoCounter.CurrentValue)
+		}
+
+		historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical counters: %s", err)
+		}
+		if len(historicalCounters) != 1 || historicalCounters[0].Value != 5 {
+			t.Fatalf("expected a single historical counter with value 5, got %+v", historicalCounters)
+		}
+		assertTimestamp(t, "created_at", historicalCounters[0].CreatedAt, occurredAt)
+
+		healthState, err := s.GetState(ctx)
+		if err != nil {
+			t.Fatalf("failed to get state: %s", err)
+		}
+		assertTimestamp(t, "changed_at", healthState.ChangedAt.String, occurredAt)
+	}
+	assertBackdated(t)
+
+	events, err := s.GetEvents(ctx)
+	if err != nil {
+		t.Fatalf("failed to get events: %s", err)
+	}
+	lastEvent := events[len(events)-1]
+	if lastEvent.Kind != EventOhno {
+		t.Fatalf("expected the last event to be %s, got %s", EventOhno, lastEvent.Kind)
+	}
+	assertTimestamp(t, "occurred_at", lastEvent.OccurredAt, occurredAt)
+
+	// Replaying the log gives the same result
+	if err := s.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+	assertBackdated(t)
+}
+
+func scenarioBackdatedTransitionRejected(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	err := s.TransitionTo(ctx, Ill, time.Now().UTC().Add(time.Hour))
+	if !errors.Is(err, ErrInvalidOccurredAt) {
+		t.Fatalf("expected ErrInvalidOccurredAt for a future occurred_at, got %v", err)
+	}
+
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	err = s.TransitionTo(ctx, Healthy, time.Now().UTC().Add(-time.Hour))
+	if !errors.Is(err, ErrInvalidOccurredAt) {
+		t.Fatalf("expected ErrInvalidOccurredAt before the previous transition, got %v", err)
+	}
+
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Ill {
+		t.Errorf("expected state to be %s, got %s", Ill, healthState.State)
+	}
+}
+
+func scenarioUndoLastTransition(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	// A tick after the backdated ohno is moved to the ohno counter and back again by the undo
+	setUpdateInterval(t, s, 0)
+	if err := s.SetCounter(ctx, 5); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	if isUpdated, err := s.UpdateCounter(ctx); err != nil || !isUpdated {
+		t.Fatalf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+	before, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	if err := s.TransitionTo(ctx, Ill, time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+
+	assertUndone := func(t *testing.T) {
+		counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+		if err != nil {
+			t.Fatalf("failed to get counter: %s", err)
+		}
+		if counter.CurrentValue != 6 || counter.MaxValue != before.MaxValue {
+			t.Errorf("expected current_value 6 and max_value %d, got %d and %d", before.MaxValue, counter.CurrentValue, counter.MaxValue)
+		}
+		if counter.IsLocked || counter.ResetedAt.Valid {
+			t.Errorf("expected an unlocked counter never reset, got %+v", counter)
+		}
+
+		ohnoCounter, err := s.GetCounter(ctx, utils.TableInstance.OhnoCounter)
+		if err != nil {
+			t.Fatalf("failed to get ohno counter: %s", err)
+		}
+		if ohnoCounter.CurrentValue != 0 || !ohnoCounter.IsLocked {
+			t.Errorf("expected an empty, locked ohno counter, got %+v", ohnoCounter)
+		}
+
+		historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical counters: %s", err)
+		}
+		if len(historicalCounters) != 0 {
+			t.Errorf("expected 0 historical counters, got %+v", historicalCounters)
+		}
+
+		healthState, err := s.GetState(ctx)
+		if err != nil {
+			t.Fatalf("failed to get state: %s", err)
+		}
+		if healthState.State != Healthy || healthState.ChangedAt.Valid {
+			t.Errorf("expected the initial %s state, got %+v", Healthy, healthState)
+		}
+	}
+
+	state, err := s.UndoLastTransition(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	if state != Healthy {
+		t.Errorf("expected state to be %s, got %s", Healthy, state)
+	}
+	assertUndone(t)
+	counter, err := s.GetCounter(ctx, utils.TableInstance.Counter)
+	if err != nil {
+		t.Fatalf("failed to get counter: %s", err)
+	}
+	assertTimestamp(t, "updated_at", counter.UpdatedAt, mustParseTimestamp(t, before.UpdatedAt))
+
+	// Replaying the log gives the same result
+	if err := s.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+	assertUndone(t)
+}
+
+func scenarioUndoRefused(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	_, err := s.UndoLastTransition(ctx, time.Minute)
+	if !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected ErrNothingToUndo without transitions, got %v", err)
+	}
+
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+	_, err = s.UndoLastTransition(ctx, 0)
+	if !errors.Is(err, ErrUndoWindowExpired) {
+		t.Fatalf("expected ErrUndoWindowExpired, got %v", err)
+	}
+
+	if _, err := s.UndoLastTransition(ctx, time.Minute); err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	// An undo cannot be undone
+	_, err = s.UndoLastTransition(ctx, time.Minute)
+	if !errors.Is(err, ErrNothingToUndo) {
+		t.Fatalf("expected ErrNothingToUndo after an undo, got %v", err)
+	}
+}
+
+func mustParseTimestamp(t *testing.T, value string) time.Time {
+	t.Helper()
+	parsed, err := parseTimestamp(value)
+	if err != nil {
+		t.Fatalf("failed to parse timestamp: %s", err)
+	}
+	return parsed
+}
+
+func scenarioIdempotencyKeys(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	stored, err := s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, time.Minute)
+	if err != nil || stored != nil {
+		t.Fatalf("expected a free key, got %+v, %v", stored, err)
+	}
+
+	_, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, time.Minute)
+	if !errors.Is(err, ErrIdempotencyKeyInFlight) {
+		t.Errorf("expected ErrIdempotencyKeyInFlight, got %v", err)
+	}
+
+	response := IdempotentResponse{StatusCode: 200, Body: `{"message":"Oh No! Event recorded"}`}
+	if err := s.CompleteIdempotencyKey(ctx, "api_key:1", "key", response); err != nil {
+		t.Fatalf("failed to complete key: %s", err)
+	}
+
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("failed to reserve key: %s", err)
+	}
+	if stored == nil || *stored != response {
+		t.Errorf("expected the stored response %+v, got %+v", response, stored)
+	}
+
+	_, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "fine-request", time.Hour, time.Minute)
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	// Another caller gets its own key
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:2", "key", "fine-request", time.Hour, time.Minute)
+	if err != nil || stored != nil {
+		t.Errorf("expected the key to be free for another caller, got %+v, %v", stored, err)
+	}
+
+	// Expired keys are forgotten
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "fine-request", -time.Second, time.Minute)
+	if err != nil || stored != nil {
+		t.Errorf("expected an expired key to be free, got %+v, %v", stored, err)
+	}
+
+	// Released keys are free again
+	if err := s.ReleaseIdempotencyKey(ctx, "api_key:1", "key"); err != nil {
+		t.Fatalf("failed to release key: %s", err)
+	}
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, time.Minute)
+	if err != nil || stored != nil {
+		t.Errorf("expected a released key to be free, got %+v, %v", stored, err)
+	}
+
+	// Reservations whose request never completed are freed before the key expires
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, -time.Second)
+	if err != nil || stored != nil {
+		t.Errorf("expected an abandoned reservation to be free, got %+v, %v", stored, err)
+	}
+	if err := s.CompleteIdempotencyKey(ctx, "api_key:1", "key", response); err != nil {
+		t.Fatalf("failed to complete key: %s", err)
+	}
+	stored, err = s.ReserveIdempotencyKey(ctx, "api_key:1", "key", "ohno-request", time.Hour, -time.Second)
+	if err != nil || stored == nil || *stored != response {
+		t.Errorf("expected a completed key to outlive the in-flight timeout, got %+v, %v", stored, err)
+	}
+}
+
+func scenarioAPIKeys(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	key, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	_, err = s.CreateAPIKey(ctx, "laptop", Role("owner"), key)
+	if !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("expected ErrInvalidRole, got %v", err)
+	}
+	created, err := s.CreateAPIKey(ctx, "laptop", RoleRecorder, key)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	if created.Name != "laptop" || created.Prefix != key[:apiKeyVisibleLength] || created.Role != RoleRecorder || created.RevokedAt != nil {
+		t.Errorf("unexpected created key %+v", created)
+	}
+	assertRecent(t, "created_at", created.CreatedAt)
+
+	_, err = s.AuthenticateAPIKey(ctx, key+"x")
+	if !errors.Is(err, ErrUnknownAPIKey) {
+		t.Errorf("expected ErrUnknownAPIKey for a wrong key, got %v", err)
+	}
+	authenticated, err := s.AuthenticateAPIKey(ctx, key)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	if authenticated.ID != created.ID || authenticated.Role != RoleRecorder || authenticated.LastUsedAt == nil {
+		t.Errorf("expected recorder key %d with last_used_at set, got %+v", created.ID, authenticated)
+	}
+	// last_used_at is only written once per apiKeyUseResolution
+	time.Sleep(10 * time.Millisecond)
+	again, err := s.AuthenticateAPIKey(ctx, key)
+	if err != nil {
+		t.Fatalf("failed to authenticate: %s", err)
+	}
+	firstUse, err := parseNullableTimestamp(authenticated.LastUsedAt)
+	if err != nil {
+		t.Fatalf("failed to parse last_used_at: %s", err)
+	}
+	secondUse, err := parseNullableTimestamp(again.LastUsedAt)
+	if err != nil {
+		t.Fatalf("failed to parse last_used_at: %s", err)
+	}
+	if firstUse == nil || secondUse == nil || !secondUse.Equal(*firstUse) {
+		t.Errorf("expected last_used_at to stay %v, got %v", firstUse, secondUse)
+	}
+
+	other, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	if _, err := s.CreateAPIKey(ctx, "phone", RoleViewer, other); err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+
+	if err := s.RevokeAPIKey(ctx, created.ID); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+	_, err = s.AuthenticateAPIKey(ctx, key)
+	if !errors.Is(err, ErrUnknownAPIKey) {
+		t.Errorf("expected ErrUnknownAPIKey for a revoked key, got %v", err)
+	}
+	if _, err := s.AuthenticateAPIKey(ctx, other); err != nil {
+		t.Errorf("expected the other key to still authenticate, got %v", err)
+	}
+	if err := s.RevokeAPIKey(ctx, created.ID+100); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("expected ErrAPIKeyNotFound, got %v", err)
+	}
+
+	apiKeys, err := s.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("failed to list keys: %s", err)
+	}
+	if len(apiKeys) != 2 || apiKeys[0].Name != "laptop" || apiKeys[1].Name != "phone" {
+		t.Fatalf("expected the laptop and phone keys, got %+v", apiKeys)
+	}
+	if apiKeys[0].RevokedAt == nil || apiKeys[1].RevokedAt != nil {
+		t.Errorf("expected only the laptop key to be revoked, got %+v", apiKeys)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	cases := []struct {
+		role     Role
+		required Role
+		allowed  bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleRecorder, false},
+		{RoleRecorder, RoleViewer, true},
+		{RoleRecorder, RoleRecorder, true},
+		{RoleRecorder, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, true},
+		{Role(""), RoleViewer, false},
+	}
+	for _, c := range cases {
+		if allowed := c.role.Allows(c.required); allowed != c.allowed {
+			t.Errorf("expected %q allowing %q to be %t, got %t", c.role, c.required, c.allowed, allowed)
+		}
+	}
+}
+
+func scenarioHistoricalPeriods(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	firstOhnoAt := now.Add(-3 * time.Hour)
+	fineAt := now.Add(-2 * time.Hour)
+	secondOhnoAt := now.Add(-time.Hour)
+	secondFineAt := now.Add(-30 * time.Minute)
+	if err := s.SetCounter(ctx, 3); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	for _, step := range []struct {
+		state      State
+		occurredAt time.Time
+	}{{Ill, firstOhnoAt}, {Healthy, fineAt}, {Ill, secondOhnoAt}, {Healthy, secondFineAt}} {
+		if err := s.TransitionTo(ctx, step.state, step.occurredAt); err != nil {
+			t.Fatalf("failed to transition to %s: %s", step.state, err)
+		}
+	}
+
+	assertPeriods := func(t *testing.T) {
+		historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical counters: %s", err)
+		}
+		if len(historicalCounters) != 2 {
+			t.Fatalf("expected 2 historical counters, got %+v", historicalCounters)
+		}
+		byValue := map[int]HistoricalCounter{}
+		for _, historicalCounter := range historicalCounters {
+			byValue[historicalCounter.Value] = historicalCounter
+		}
+
+		// No transition happened before the first ohno, its start is unknown
+		first := byValue[3]
+		if first.StartedAt != nil {
+			t.Errorf("expected started_at to be nil, got %s", *first.StartedAt)
+		}
+		assertNullableTimestamp(t, "ended_at", first.EndedAt, firstOhnoAt)
+
+		second := byValue[1]
+		assertNullableTimestamp(t, "started_at", second.StartedAt, fineAt)
+		assertNullableTimestamp(t, "ended_at", second.EndedAt, secondOhnoAt)
+
+		ohnoCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical ohno counters: %s", err)
+		}
+		if len(ohnoCounters) != 1 {
+			t.Fatalf("expected a single historical ohno counter, got %+v", ohnoCounters)
+		}
+		// The first ill period left no row, the ohno counter was created by the first fine
+		assertNullableTimestamp(t, "started_at", ohnoCounters[0].StartedAt, secondOhnoAt)
+		assertNullableTimestamp(t, "ended_at", ohnoCounters[0].EndedAt, secondFineAt)
+	}
+	assertPeriods(t)
+
+	// Replaying the log gives the same periods
+	if err := s.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+	assertPeriods(t)
+}
+
+func assertNullableTimestamp(t *testing.T, field string, value *string, expected time.Time) {
+	t.Helper()
+	if value == nil {
+		t.Fatalf("expected %s to be set", field)
+	}
+	assertTimestamp(t, field, *value, expected)
+}
+
+func seedHistoricalPage(t *testing.T, b storeBackend, s CounterStore) {
+	tableName := utils.TableInstance.HistoricalCounter
+	for _, historicalCounter := range []HistoricalCounter{
+		{CounterID: "00000000-0000-0000-0000-000000000003", CreatedAt: "2024-06-03T12:00:00.000Z", Value: 7},
+		{CounterID: "00000000-0000-0000-0000-000000000001", CreatedAt: "2024-06-01T12:00:00.000Z", Value: 3},
+		{CounterID: "00000000-0000-0000-0000-000000000005", CreatedAt: "2024-06-02T12:00:00.000Z", Value: 7},
+		{CounterID: "00000000-0000-0000-0000-000000000002", CreatedAt: "2024-06-02T12:00:00.000Z", Value: 5},
+		{CounterID: "00000000-0000-0000-0000-000000000004", CreatedAt: "2024-06-04T12:00:00.000Z", Value: 1},
+	} {
+		b.seedHistorical(t, s, tableName, historicalCounter)
+	}
+}
+
+// listAllHistoricalCounters follows the cursors until the last page and returns the ids of
+// every row in order.
+func listAllHistoricalCounters(t *testing.T, s CounterStore, query HistoricalQuery) []string {
+	ctx := context.Background()
+	t.Helper()
+	var ids []string
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("expected pagination to end, got %v so far", ids)
+		}
+		page, err := s.ListHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter, query)
+		if err != nil {
+			t.Fatalf("failed to list historical counters: %s", err)
+		}
+		if len(page.Items) > query.Limit {
+			t.Fatalf("expected at most %d items, got %d", query.Limit, len(page.Items))
+		}
+		for _, item := range page.Items {
+			ids = append(ids, item.CounterID[len(item.CounterID)-1:])
+		}
+		if page.NextCursor == nil {
+			return ids
+		}
+		query.Cursor = *page.NextCursor
+	}
+}
+
+func scenarioListHistoricalCounters(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	seedHistoricalPage(t, b, s)
+
+	from := time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)
+	minValue, maxValue := 4, 7
+	tests := []struct {
+		name     string
+		query    HistoricalQuery
+		expected string
+	}{
+		{"default order", HistoricalQuery{Limit: 2}, "12534"},
+		{"created_at descending", HistoricalQuery{Sort: SortCreatedAtDesc, Limit: 2}, "43521"},
+		{"value ascending", HistoricalQuery{Sort: SortValueAsc, Limit: 2}, "41235"},
+		{"value descending", HistoricalQuery{Sort: SortValueDesc, Limit: 3}, "53214"},
+		{"single page", HistoricalQuery{Limit: 10}, "12534"},
+		{"date range", HistoricalQuery{From: &from, To: &to, Limit: 1}, "253"},
+		{"value range", HistoricalQuery{MinValue: &minValue, MaxValue: &maxValue, Sort: SortValueDesc, Limit: 1}, "532"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := strings.Join(listAllHistoricalCounters(t, s, tt.query), "")
+			if ids != tt.expected {
+				t.Errorf("expected rows %s, got %s", tt.expected, ids)
+			}
+		})
+	}
+
+	// An exact page has no next cursor
+	page, err := s.ListHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter, HistoricalQuery{Limit: 5})
+	if err != nil {
+		t.Fatalf("failed to list historical counters: %s", err)
+	}
+	if len(page.Items) != 5 || page.NextCursor != nil {
+		t.Errorf("expected 5 items and no next cursor, got %d items and %v", len(page.Items), page.NextCursor)
+	}
+}
+
+func scenarioListHistoricalCountersInvalidCursor(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	seedHistoricalPage(t, b, s)
+
+	_, err := s.ListHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter, HistoricalQuery{Cursor: "not a cursor"})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	// Cursors only apply to the sort they were issued for
+	page, err := s.ListHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter, HistoricalQuery{Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to list historical counters: %s", err)
+	}
+	if page.NextCursor == nil {
+		t.Fatalf("expected a next cursor")
+	}
+	_, err = s.ListHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter, HistoricalQuery{Sort: SortValueAsc, Cursor: *page.NextCursor})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func scenarioExport(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{
+		CurrentValue: 4,
+		MaxValue:     9,
+		UpdatedAt:    "2024-06-05T00:00:00.000Z",
+		ResetedAt:    sql.NullString{String: "2024-06-01T00:00:00.000Z", Valid: true},
+	})
+	b.seedHistorical(t, s, utils.TableInstance.HistoricalCounter, HistoricalCounter{CounterID: "00000000-0000-0000-0000-000000000002", CreatedAt: "2024-05-20T00:00:00.000Z", Value: 9})
+	b.seedHistorical(t, s, utils.TableInstance.HistoricalCounter, HistoricalCounter{CounterID: "00000000-0000-0000-0000-000000000001", CreatedAt: "2024-05-10T00:00:00.000Z", Value: 3})
+	b.seedHistorical(t, s, utils.TableInstance.HistoricalOhnoCounter, HistoricalCounter{CounterID: "00000000-0000-0000-0000-000000000003", CreatedAt: "2024-06-01T00:00:00.000Z", Value: 2})
+
+	var records []ExportRecord
+	err := s.Export(ctx, func(record ExportRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+
+	if len(records) != 4 {
+		t.Fatalf("expected 4 records, got %+v", records)
+	}
+	counter := records[0]
+	if counter.Table != utils.TableInstance.Counter || counter.Value != 4 || counter.MaxValue == nil || *counter.MaxValue != 9 || counter.CounterID != nil {
+		t.Errorf("expected the counter row first, got %+v", counter)
+	}
+	assertTimestamp(t, "updated_at", counter.UpdatedAt, time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC))
+	assertNullableTimestamp(t, "reseted_at", counter.ResetedAt, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	// Historical rows follow, oldest first within each table
+	var ids []string
+	for _, record := range records[1:] {
+		if record.CounterID == nil || record.CreatedAt == nil || record.MaxValue != nil {
+			t.Fatalf("expected a historical row, got %+v", record)
+		}
+		ids = append(ids, record.Table+"/"+(*record.CounterID)[len(*record.CounterID)-1:])
+	}
+	expected := "historical_counter/1 historical_counter/2 historical_ohno_counter/3"
+	if strings.Join(ids, " ") != expected {
+		t.Errorf("expected %s, got %s", expected, strings.Join(ids, " "))
+	}
+	assertNullableTimestamp(t, "ended_at", records[1].EndedAt, time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+
+	// Errors of the callback stop the export
+	stop := errors.New("stop")
+	calls := 0
+	err = s.Export(ctx, func(record ExportRecord) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("expected the export to stop after the first record, got %v after %d calls", err, calls)
+	}
+}
+
+func scenarioExportDoesNotBlockWrites(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedCounter(t, s, utils.TableInstance.Counter, Counter{CurrentValue: 4, MaxValue: 9, UpdatedAt: "2024-06-05T00:00:00.000Z"})
+	b.seedHistorical(t, s, utils.TableInstance.HistoricalCounter, HistoricalCounter{CounterID: "00000000-0000-0000-0000-000000000001", CreatedAt: "2024-05-10T00:00:00.000Z", Value: 3})
+
+	// Writes happen while the export streams, as they do while a client downloads /export
+	var records []ExportRecord
+	var writeErr error
+	err := s.Export(ctx, func(record ExportRecord) error {
+		if len(records) == 0 {
+			writeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+			writeErr = s.SetCounter(writeCtx, 12)
+			if writeErr == nil {
+				writeErr = s.TransitionTo(writeCtx, Ill, time.Time{})
+			}
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	if writeErr != nil {
+		t.Fatalf("expected writes to succeed during the export, got %s", writeErr)
+	}
+	if len(records) != 2 || records[0].Value != 4 {
+		t.Errorf("expected the export to keep its snapshot, got %+v", records)
+	}
+
+	healthState, err := s.GetState(ctx)
+	if err != nil {
+		t.Fatalf("failed to get state: %s", err)
+	}
+	if healthState.State != Ill {
+		t.Errorf("expected the transition to be committed, got state %s", healthState.State)
+	}
+}
+
+func exportCSV(t *testing.T, records ...ExportRecord) string {
+	t.Helper()
+	var builder strings.Builder
+	writer := csv.NewWriter(&builder)
+	writer.Write(ExportCSVHeader)
+	for _, record := range records {
+		writer.Write(record.CSVRow())
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		t.Fatalf("failed to write csv: %s", err)
+	}
+	return builder.String()
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func scenarioImportHistorical(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	input := exportCSV(t,
+		ExportRecord{Table: utils.TableInstance.Counter, Value: 4, MaxValue: ptr(9), UpdatedAt: "2024-06-05T00:00:00Z"},
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, CounterID: ptr("00000000-0000-0000-0000-000000000001"), Value: 12, StartedAt: ptr("2020-01-01T00:00:00Z"), EndedAt: ptr("2020-01-13T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 30, CreatedAt: ptr("2020-02-20T00:00:00Z"), StartedAt: ptr("2020-01-16T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalOhnoCounter, CounterID: ptr("00000000-0000-0000-0000-000000000003"), Value: 3, StartedAt: ptr("2020-01-13T00:00:00Z"), EndedAt: ptr("2020-01-16T00:00:00Z")},
+	)
+
+	report, err := ImportCSV(ctx, s, strings.NewReader(input), true)
+	if err != nil {
+		t.Fatalf("failed to dry run import: %s", err)
+	}
+	if !report.DryRun || report.Inserted[utils.TableInstance.HistoricalCounter] != 2 || report.Inserted[utils.TableInstance.HistoricalOhnoCounter] != 1 || report.IgnoredCounters != 1 {
+		t.Errorf("expected 2 + 1 rows to be imported and the counter to be ignored, got %+v", report)
+	}
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 0 {
+		t.Fatalf("expected a dry run to import nothing, got %+v", historicalCounters)
+	}
+
+	report, err = ImportCSV(ctx, s, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	if report.DryRun || report.Inserted[utils.TableInstance.HistoricalCounter] != 2 {
+		t.Errorf("expected 2 rows to be imported, got %+v", report)
+	}
+
+	assertImported := func(t *testing.T) {
+		historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical counters: %s", err)
+		}
+		if len(historicalCounters) != 2 || historicalCounters[0].Value != 12 || historicalCounters[1].Value != 30 {
+			t.Fatalf("expected the imported rows, got %+v", historicalCounters)
+		}
+		if historicalCounters[0].CounterID != "00000000-0000-0000-0000-000000000001" {
+			t.Errorf("expected the counter_id to be kept, got %s", historicalCounters[0].CounterID)
+		}
+		assertTimestamp(t, "created_at", historicalCounters[1].CreatedAt, time.Date(2020, 2, 20, 0, 0, 0, 0, time.UTC))
+		assertNullableTimestamp(t, "started_at", historicalCounters[1].StartedAt, time.Date(2020, 1, 16, 0, 0, 0, 0, time.UTC))
+		assertNullableTimestamp(t, "ended_at", historicalCounters[1].EndedAt, time.Date(2020, 2, 20, 0, 0, 0, 0, time.UTC))
+
+		ohnoCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+		if err != nil {
+			t.Fatalf("failed to get historical ohno counters: %s", err)
+		}
+		if len(ohnoCounters) != 1 || ohnoCounters[0].Value != 3 {
+			t.Fatalf("expected the imported ohno row, got %+v", ohnoCounters)
+		}
+	}
+	assertImported(t)
+
+	// The import is part of the event log
+	if err := s.RebuildFromEvents(ctx); err != nil {
+		t.Fatalf("failed to rebuild from events: %s", err)
+	}
+	assertImported(t)
+
+	// Importing the same rows again only changes the rows without a counter_id
+	input = exportCSV(t,
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, CounterID: ptr("00000000-0000-0000-0000-000000000001"), Value: 12, StartedAt: ptr("2020-01-01T00:00:00Z"), EndedAt: ptr("2020-01-13T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalOhnoCounter, CounterID: ptr("00000000-0000-0000-0000-000000000003"), Value: 3, StartedAt: ptr("2020-01-13T00:00:00Z"), EndedAt: ptr("2020-01-16T00:00:00Z")},
+	)
+	report, err = ImportCSV(ctx, s, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("failed to import again: %s", err)
+	}
+	if report.AlreadyPresent != 2 || report.Inserted[utils.TableInstance.HistoricalCounter] != 0 {
+		t.Errorf("expected both rows to be already present, got %+v", report)
+	}
+}
+
+func scenarioImportRejected(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	b.seedHistorical(t, s, utils.TableInstance.HistoricalCounter, HistoricalCounter{CounterID: "00000000-0000-0000-0000-000000000009", CreatedAt: "2021-01-10T00:00:00.000Z", Value: 5})
+
+	input := exportCSV(t,
+		// Line 2 is fine on its own but overlaps line 3
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 10, StartedAt: ptr("2020-01-01T00:00:00Z"), EndedAt: ptr("2020-01-11T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 10, StartedAt: ptr("2020-01-05T00:00:00Z"), EndedAt: ptr("2020-01-15T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 0, EndedAt: ptr("2020-03-01T00:00:00Z")},
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 2, EndedAt: ptr("yesterday")},
+		// Overlaps the seeded row
+		ExportRecord{Table: utils.TableInstance.HistoricalCounter, Value: 9, StartedAt: ptr("2021-01-01T00:00:00Z"), EndedAt: ptr("2021-01-20T00:00:00Z")},
+		// An ill period within a healthy one
+		ExportRecord{Table: utils.TableInstance.HistoricalOhnoCounter, Value: 2, StartedAt: ptr("2021-01-12T00:00:00Z"), EndedAt: ptr("2021-01-14T00:00:00Z")},
+	) + "1,historical_counter,,3\n"
+
+	report, err := ImportCSV(ctx, s, strings.NewReader(input), false)
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Fatalf("expected ErrInvalidImport, got %v", err)
+	}
+	var lines []int
+	for _, importError := range report.Errors {
+		lines = append(lines, importError.Line)
+	}
+	if fmt.Sprint(lines) != "[3 4 5 6 7 8]" {
+		t.Errorf("expected errors on lines 3 to 8, got %+v", report.Errors)
+	}
+	if message := report.Errors[4].Message; message != "period overlaps line 6 in historical_counter" {
+		t.Errorf("expected the ill period to overlap line 6 in historical_counter, got %q", message)
+	}
+
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 1 {
+		t.Errorf("expected nothing to be imported, got %+v", historicalCounters)
+	}
+
+	_, err = ImportCSV(ctx, s, strings.NewReader("table,value\n"), false)
+	if !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("expected ErrInvalidImport for an unknown header, got %v", err)
+	}
+}
+
+func scenarioCalendarUID(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	ohnoAt := time.Date(2024, 3, 20, 12, 0, 0, 0, time.UTC)
+	if err := s.SetCounter(ctx, 3); err != nil {
+		t.Fatalf("failed to set counter: %s", err)
+	}
+	if err := s.TransitionTo(ctx, Ill, ohnoAt); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+	calendarUIDs := func() []string {
+		t.Helper()
+		calendar, err := BuildCalendar(ctx, s, time.Now().UTC(), time.UTC)
+		if err != nil {
+			t.Fatalf("failed to build calendar: %s", err)
+		}
+		var uids []string
+		for _, line := range strings.Split(calendar, "\r\n") {
+			if uid, ok := strings.CutPrefix(line, "UID:"); ok {
+				uids = append(uids, uid)
+			}
+		}
+		return uids
+	}
+
+	open := calendarUIDs()
+	if len(open) != 1 {
+		t.Fatalf("expected the open ill period, got %v", open)
+	}
+
+	// The period keeps its UID once it is finished, its row gets the counter_id it was
+	// identified by
+	if isUpdated, err := s.UpdateOhnoCounter(ctx); err != nil || !isUpdated {
+		t.Fatalf("expected isUpdated to be true, got %v (error: %v)", isUpdated, err)
+	}
+	if err := s.TransitionTo(ctx, Healthy, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Healthy, err)
+	}
+	finished := calendarUIDs()
+	if strings.Join(finished, " ") != strings.Join(open, " ") {
+		t.Errorf("expected the finished period to keep UID %v, got %v", open, finished)
+	}
+	historicalCounters, err := s.GetHistoricalCounters(ctx, utils.TableInstance.HistoricalOhnoCounter)
+	if err != nil {
+		t.Fatalf("failed to get historical counters: %s", err)
+	}
+	if len(historicalCounters) != 1 || historicalCounters[0].CounterID+"@oh-no" != open[0] {
+		t.Errorf("expected a row with counter_id %s, got %+v", open[0], historicalCounters)
+	}
+}
+
+func scenarioReadiness(t *testing.T, b storeBackend, s CounterStore) {
+	ctx := context.Background()
+	assertReady := func(state State) {
+		t.Helper()
+		readiness := CheckReadiness(ctx, s)
+		if !readiness.Ready {
+			t.Errorf("expected to be ready while %s, got %+v", state, readiness)
+		}
+		var names []string
+		for _, check := range readiness.Checks {
+			names = append(names, check.Name)
+		}
+		if strings.Join(names, ",") != "database,schema,state" {
+			t.Errorf("expected database, schema and state checks, got %v", names)
+		}
+		if err := CheckReplay(ctx, s); err != nil {
+			t.Errorf("expected the events to lead to %s, got %s", state, err)
+		}
+	}
+
+	assertReady(Healthy)
+	if err := s.TransitionTo(ctx, Ill, time.Time{}); err != nil {
+		t.Fatalf("failed to transition to %s: %s", Ill, err)
+	}
+	assertReady(Ill)
+	if _, err := s.UndoLastTransition(ctx, time.Hour); err != nil {
+		t.Fatalf("failed to undo: %s", err)
+	}
+	assertReady(Healthy)
+
+	// A state the events do not lead to, as left by a write bypassing the store
+	switch store := s.(type) {
+	case *MemoryStore:
+		store.state = Ill
+	case *SQLStore:
+		_, err := store.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = 'ill'`, utils.TableInstance.HealthState))
+		if err != nil {
+			t.Fatalf("failed to update state: %s", err)
+		}
+	}
+	readiness := CheckReadiness(ctx, s)
+	if readiness.Ready || readiness.Checks[len(readiness.Checks)-1].OK {
+		t.Errorf("expected the state check to fail, got %+v", readiness)
+	}
+	if err := CheckReplay(ctx, s); err == nil {
+		t.Error("expected the replay check to fail")
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"server/utils"
+	"sort"
+	"time"
+)
+
+// TimelinePeriod is a healthy or ill stretch. StartedAt is nil when the start is unknown,
+// EndedAt is nil for the current open period. DurationSeconds is only set when the start is
+// known, open periods last until now.
+type TimelinePeriod struct {
+	Kind            State   `json:"kind"`
+	CounterID       string  `json:"counter_id,omitempty"`
+	StartedAt       *string `json:"started_at"`
+	EndedAt         *string `json:"ended_at"`
+	DurationSeconds *int64  `json:"duration_seconds"`
+	Value           int     `json:"value"`
+}
+
+type timelineRow struct {
+	kind      State
+	counterId string
+	endedAt   time.Time
+	value     int
+}
+
+// BuildTimeline merges both historical tables and the current open period into a single,
+// chronologically ordered list of periods. A period starts when the previous one ended, the
+// first one starts at the started_at of its row, when known. Transitions that ended a
+// period with a value of 0 leave no historical row, such a period is folded into the next one.
+func BuildTimeline(ctx context.Context, store CounterStore, now time.Time) ([]TimelinePeriod, error) {
+	var rows []timelineRow
+	var firstStartedAt *time.Time
+	var firstEndedAt *time.Time
+	for tableName, kind := range map[string]State{
+		utils.TableInstance.HistoricalCounter:     Healthy,
+		utils.TableInstance.HistoricalOhnoCounter: Ill,
+	} {
+		historicalCounters, err := store.GetHistoricalCounters(ctx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		for _, historicalCounter := range historicalCounters {
+			// Rows created before ended_at existed end when they were created
+			endedAtValue := historicalCounter.CreatedAt
+			if historicalCounter.EndedAt != nil {
+				endedAtValue = *historicalCounter.EndedAt
+			}
+			endedAt, err := parseTimestamp(endedAtValue)
+			if err != nil {
+				return nil, fmt.Errorf("❌ Error parsing ended_at timestamp.\n %s", err)
+			}
+			startedAt, err := parseNullableTimestamp(historicalCounter.StartedAt)
+			if err != nil {
+				return nil, fmt.Errorf("❌ Error parsing started_at timestamp.\n %s", err)
+			}
+			if firstEndedAt == nil || endedAt.Before(*firstEndedAt) {
+				firstEndedAt = &endedAt
+				firstStartedAt = startedAt
+			}
+			rows = append(rows, timelineRow{kind: kind, counterId: historicalCounter.CounterID, endedAt: endedAt, value: historicalCounter.Value})
+		}
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if !rows[i].endedAt.Equal(rows[j].endedAt) {
+			return rows[i].endedAt.Before(rows[j].endedAt)
+		}
+		return rows[i].counterId < rows[j].counterId
+	})
+
+	timeline := make([]TimelinePeriod, 0, len(rows)+1)
+	startedAt := firstStartedAt
+	for _, row := range rows {
+		endedAt := row.endedAt
+		timeline = append(timeline, newTimelinePeriod(row.kind, row.counterId, startedAt, &endedAt, row.value))
+		startedAt = &endedAt
+	}
+
+	healthState, err := store.GetState(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if healthState.ChangedAt.Valid {
+		changedAt, err := parseTimestamp(healthState.ChangedAt.String)
+		if err != nil {
+			return nil, fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+		}
+		startedAt = &changedAt
+	}
+	counter, err := store.GetCounter(ctx, healthState.State.ActiveCounter())
+	if err != nil {
+		return nil, err
+	}
+	current := newTimelinePeriod(healthState.State, "", startedAt, nil, counter.CurrentValue)
+	if startedAt != nil {
+		duration := int64(now.Sub(*startedAt).Seconds())
+		current.DurationSeconds = &duration
+	}
+	timeline = append(timeline, current)
+	return timeline, nil
+}
+
+func newTimelinePeriod(kind State, counterId string, startedAt *time.Time, endedAt *time.Time, value int) TimelinePeriod {
+	period := TimelinePeriod{
+		Kind:      kind,
+		CounterID: counterId,
+		StartedAt: formatNullableTimestamp(startedAt),
+		EndedAt:   formatNullableTimestamp(endedAt),
+		Value:     value,
+	}
+	if startedAt != nil && endedAt != nil {
+		duration := int64(endedAt.Sub(*startedAt).Seconds())
+		period.DurationSeconds = &duration
+	}
+	return period
+}
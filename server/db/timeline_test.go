@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"server/utils"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixturePeriod is a finished period seeded into a timelineFixture. A zero startedAt leaves
+// the start unknown.
+type fixturePeriod struct {
+	kind      State
+	startedAt time.Time
+	endedAt   time.Time
+	value     int
+}
+
+// timelineFixture describes the history of an in-memory store: the finished periods, the
+// current state entered at changedAt, zero when unknown, and the value of its counter.
+type timelineFixture struct {
+	periods   []fixturePeriod
+	state     State
+	changedAt time.Time
+	counter   int
+}
+
+func (f timelineFixture) store() *MemoryStore {
+	s := NewMemoryStore()
+	for i, period := range f.periods {
+		endedAt := period.endedAt
+		row := memoryHistoricalCounter{
+			counterId: fmt.Sprintf("00000000-0000-0000-0000-%012d", i+1),
+			createdAt: endedAt,
+			updatedAt: endedAt,
+			endedAt:   &endedAt,
+			value:     period.value,
+		}
+		if !period.startedAt.IsZero() {
+			startedAt := period.startedAt
+			row.startedAt = &startedAt
+		}
+		tableName := utils.TableInstance.HistoricalCounter
+		if period.kind == Ill {
+			tableName = utils.TableInstance.HistoricalOhnoCounter
+		}
+		s.historical[tableName] = append(s.historical[tableName], row)
+	}
+
+	s.state = f.state
+	if s.state == "" {
+		s.state = InitialState
+	}
+	if !f.changedAt.IsZero() {
+		changedAt := f.changedAt
+		s.changedAt = &changedAt
+	}
+	if f.counter > 0 {
+		s.counters[s.state.ActiveCounter()] = &memoryCounter{currentValue: f.counter, maxValue: f.counter, updatedAt: f.changedAt}
+	}
+	return s
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// describeTimeline renders every period as "kind value start..end duration", ? standing for an
+// unknown start or duration and an empty end for the open period.
+func describeTimeline(t *testing.T, timeline []TimelinePeriod) string {
+	t.Helper()
+	format := func(value *string) string {
+		if value == nil {
+			return ""
+		}
+		return mustParseTimestamp(t, *value).Format("2006-01-02T15:04")
+	}
+
+	var periods []string
+	for _, period := range timeline {
+		startedAt := format(period.StartedAt)
+		if startedAt == "" {
+			startedAt = "?"
+		}
+		duration := "?"
+		if period.DurationSeconds != nil {
+			duration = (time.Duration(*period.DurationSeconds) * time.Second).String()
+		}
+		periods = append(periods, fmt.Sprintf("%s %d %s..%s %s", period.Kind, period.Value, startedAt, format(period.EndedAt), duration))
+	}
+	return strings.Join(periods, ", ")
+}
+
+func TestBuildTimeline(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fixture  timelineFixture
+		now      time.Time
+		expected string
+	}{
+		{
+			name:     "empty",
+			now:      date(2024, 1, 1),
+			expected: "healthy 0 ?.. ?",
+		},
+		{
+			name: "alternating periods",
+			fixture: timelineFixture{
+				periods: []fixturePeriod{
+					{kind: Ill, endedAt: date(2024, 1, 15), value: 2},
+					{kind: Healthy, endedAt: date(2024, 1, 10), value: 5},
+					{kind: Healthy, endedAt: date(2024, 3, 1), value: 10},
+				},
+				state:     Ill,
+				changedAt: date(2024, 3, 1),
+				counter:   3,
+			},
+			now: date(2024, 3, 3),
+			expected: "healthy 5 ?..2024-01-10T00:00 ?, " +
+				"ill 2 2024-01-10T00:00..2024-01-15T00:00 120h0m0s, " +
+				"healthy 10 2024-01-15T00:00..2024-03-01T00:00 1104h0m0s, " +
+				"ill 3 2024-03-01T00:00.. 48h0m0s",
+		},
+		{
+			name: "first period with a known start",
+			fixture: timelineFixture{
+				periods:   []fixturePeriod{{kind: Healthy, startedAt: date(2024, 1, 1), endedAt: date(2024, 1, 10), value: 9}},
+				state:     Ill,
+				changedAt: date(2024, 1, 10),
+				counter:   1,
+			},
+			now:      date(2024, 1, 10).Add(time.Hour),
+			expected: "healthy 9 2024-01-01T00:00..2024-01-10T00:00 216h0m0s, ill 1 2024-01-10T00:00.. 1h0m0s",
+		},
+		{
+			// A transition that ended a period of 0 left no row
+			name: "period without row",
+			fixture: timelineFixture{
+				periods:   []fixturePeriod{{kind: Healthy, endedAt: date(2024, 1, 10), value: 5}},
+				state:     Healthy,
+				changedAt: date(2024, 1, 10).Add(time.Hour),
+				counter:   1,
+			},
+			now:      date(2024, 1, 11),
+			expected: "healthy 5 ?..2024-01-10T00:00 ?, healthy 1 2024-01-10T01:00.. 23h0m0s",
+		},
+		{
+			name: "open period with an unknown start",
+			fixture: timelineFixture{
+				periods: []fixturePeriod{{kind: Healthy, endedAt: date(2024, 1, 10), value: 5}},
+				state:   Ill,
+				counter: 2,
+			},
+			now:      date(2024, 1, 11),
+			expected: "healthy 5 ?..2024-01-10T00:00 ?, ill 2 2024-01-10T00:00.. 24h0m0s",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			timeline, err := BuildTimeline(context.Background(), tc.fixture.store(), tc.now)
+			if err != nil {
+				t.Fatalf("failed to build timeline: %s", err)
+			}
+			if described := describeTimeline(t, timeline); described != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, described)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"time"
+)
+
+// sqlTimestampLayout is the layout of timestamps passed to SQL queries. It matches the text
+// SQLite stores by default, so that timestamps written by the database and by the store sort
+// the same way.
+const sqlTimestampLayout = "2006-01-02T15:04:05.000Z"
+
+func formatSQLTimestamp(t time.Time) string {
+	return t.UTC().Format(sqlTimestampLayout)
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func formatNullableTimestamp(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := formatTimestamp(*t)
+	return &formatted
+}
+
+// parseTimestamp parses timestamps as returned by the stores as well as the ones Postgres
+// writes into JSON payloads, which come without a time zone.
+func parseTimestamp(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02T15:04:05.999999999", value)
+}
+
+func parseNullableTimestamp(value *string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+	t, err := parseTimestamp(*value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
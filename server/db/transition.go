@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"server/utils"
+	"time"
+)
+
+// transition describes which tables are touched when entering a state. The counter of the
+// state being left is reset and its last value ends up in the historical table, the counter
+// of the state being entered becomes the active one.
+type transition struct {
+	tableToReset    string
+	tableToActivate string
+	historicalTable string
+	eventKind       EventKind
+}
+
+var transitions = map[State]transition{
+	Ill: {
+		tableToReset:    utils.TableInstance.Counter,
+		tableToActivate: utils.TableInstance.OhnoCounter,
+		historicalTable: utils.TableInstance.HistoricalCounter,
+		eventKind:       EventOhno,
+	},
+	Healthy: {
+		tableToReset:    utils.TableInstance.OhnoCounter,
+		tableToActivate: utils.TableInstance.Counter,
+		historicalTable: utils.TableInstance.HistoricalOhnoCounter,
+		eventKind:       EventFine,
+	},
+}
+
+// validateOccurredAt rejects transitions dated in the future or before the previous
+// transition, changedAt.
+func validateOccurredAt(occurredAt time.Time, now time.Time, changedAt *time.Time) error {
+	if occurredAt.After(now) {
+		return fmt.Errorf("%w: %s is in the future", ErrInvalidOccurredAt, occurredAt.Format(time.RFC3339))
+	}
+	if changedAt != nil && occurredAt.Before(*changedAt) {
+		return fmt.Errorf("%w: %s is before the previous transition at %s", ErrInvalidOccurredAt, occurredAt.Format(time.RFC3339), changedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// TransitionTo moves the counters into the given state. The state check, reset, state change,
+// history insert and event append run in a single transaction, so either all of them are
+// applied or none.
+// A backdated transition resets the counter as of occurredAt. The ticks it received after
+// occurredAt are taken off the value written to the historical table and credited to the
+// counter being activated, as if the transition had been recorded in time.
+// The historical row spans the period being ended, from the previous transition up to occurredAt.
+// Transitions that are not allowed from the current state fail with ErrIllegalTransition.
+func (s *SQLStore) TransitionTo(ctx context.Context, state State, occurredAt time.Time) error {
+	t, ok := transitions[state]
+	if !ok {
+		return fmt.Errorf("❌ Error transitioning. Unknown state: %s", state)
+	}
+
+	now := time.Now().UTC()
+	if occurredAt.IsZero() {
+		occurredAt = now
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	current, err := s.lockState(tx)
+	if err != nil {
+		return err
+	}
+
+	if !current.State.CanTransitionTo(state) {
+		err = fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, current.State, state)
+		return err
+	}
+
+	var changedAt *time.Time
+	if current.ChangedAt.Valid {
+		changedAt, err = parseNullableTimestamp(&current.ChangedAt.String)
+		if err != nil {
+			return fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+		}
+	}
+	err = validateOccurredAt(occurredAt, now, changedAt)
+	if err != nil {
+		return err
+	}
+	at := formatSQLTimestamp(occurredAt)
+
+	movedTicks, lastTickAt, err := s.ticksAfter(tx, t.tableToReset, at)
+	if err != nil {
+		return err
+	}
+
+	undo := &TransitionUndo{}
+	if current.ChangedAt.Valid {
+		undo.ChangedAt = &current.ChangedAt.String
+	}
+	undo.ResetCounter, err = s.readCounter(tx, t.tableToReset)
+	if err != nil {
+		return err
+	}
+
+	lastValue, err := s.resetCounter(ctx, tx, t.tableToReset, at)
+	if err != nil {
+		return err
+	}
+	lastValue = max(lastValue-movedTicks, 0)
+
+	if movedTicks > 0 {
+		slog.InfoContext(ctx, "⏪ Moving ticks...", "ticks", movedTicks, "after", at, "from", t.tableToReset, "to", t.tableToActivate)
+		undo.ActivatedCounter, err = s.readCounter(tx, t.tableToActivate)
+		if err != nil {
+			return err
+		}
+		err = s.creditTicks(tx, t.tableToActivate, movedTicks, lastTickAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(ctx, "🔀 Changing state...", "from", current.State, "to", state)
+	err = s.setState(tx, state, &at)
+	if err != nil {
+		return err
+	}
+
+	var startedAt *string
+	if current.ChangedAt.Valid {
+		startedAt = &current.ChangedAt.String
+	}
+	periodStart, err := parseNullableTimestamp(startedAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error parsing changed_at timestamp.\n %s", err)
+	}
+	counterId, err := s.createHistoricalCounter(ctx, tx, t.historicalTable, periodCounterID(t.historicalTable, periodStart), lastValue, startedAt, at)
+	if err != nil {
+		return err
+	}
+
+	payload := TransitionPayload{CounterID: counterId, Value: lastValue, MovedTicks: movedTicks, LastTickAt: lastTickAt, Undo: undo}
+	err = s.appendEventAt(tx, t.eventKind, payload, at)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	return nil
+}
+
+// lockState reads the current state within the given transaction and locks its row until the
+// transaction ends.
+func (s *SQLStore) lockState(tx *sql.Tx) (HealthState, error) {
+	var healthState HealthState
+	query := fmt.Sprintf(`
+		SELECT
+			state, changed_at
+		FROM %s
+		LIMIT 1
+		%s
+	`, utils.TableInstance.HealthState, s.dialect.forUpdate)
+
+	err := tx.QueryRow(query).Scan(&healthState.State, &healthState.ChangedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return HealthState{State: InitialState}, nil
+		}
+		return HealthState{}, fmt.Errorf("❌ Error querying %s table.\n %s", utils.TableInstance.HealthState, err)
+	}
+	return healthState, nil
+}
+
+// setState persists the given state, entered at changedAt, within the given transaction,
+// initializing the state row if it does not exist yet.
+func (s *SQLStore) setState(tx *sql.Tx, state State, changedAt *string) error {
+	tableName := utils.TableInstance.HealthState
+	upsertQuery := fmt.Sprintf(`
+		INSERT INTO %s (id, state, changed_at)
+		VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE
+		SET state = EXCLUDED.state, changed_at = EXCLUDED.changed_at
+	`, tableName)
+
+	_, err := tx.Exec(upsertQuery, string(state), changedAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	return nil
+}
+
+// ticksAfter counts the tick events of the counter stored in tableName that occurred after
+// the given timestamp and returns the occurred_at of the latest of them.
+func (s *SQLStore) ticksAfter(tx *sql.Tx, tableName string, after string) (int, string, error) {
+	var ticks int
+	var lastTickAt sql.NullString
+	query := fmt.Sprintf(`
+		SELECT
+			COUNT(*), MAX(occurred_at)
+		FROM %s
+		WHERE kind = $1 AND payload->>'table' = $2 AND %s > %s
+	`, utils.TableInstance.Events, s.dialect.timestamp("occurred_at"), s.dialect.timestamp("$3"))
+
+	err := tx.QueryRow(query, string(EventTick), tableName, after).Scan(&ticks, &lastTickAt)
+	if err != nil {
+		return 0, "", fmt.Errorf("❌ Error querying %s table.\n %s", utils.TableInstance.Events, err)
+	}
+	return ticks, lastTickAt.String, nil
+}
+
+// creditTicks applies the given number of ticks to the counter stored in tableName, the
+// latest of them at lastTickAt.
+func (s *SQLStore) creditTicks(tx *sql.Tx, tableName string, ticks int, lastTickAt string) error {
+	var currentValue int
+	query := fmt.Sprintf(`
+		SELECT
+			current_value
+		FROM %s
+		LIMIT 1
+		%s
+	`, tableName, s.dialect.forUpdate)
+
+	err := tx.QueryRow(query).Scan(&currentValue)
+	if err == sql.ErrNoRows {
+		insertQuery := fmt.Sprintf(`
+			INSERT INTO %s (current_value, max_value, updated_at)
+			VALUES ($1, $1, $2)
+		`, tableName)
+		_, err = tx.Exec(insertQuery, ticks, lastTickAt)
+		if err != nil {
+			return fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+
+	updateQuery := fmt.Sprintf(`
+		UPDATE %s
+		SET current_value = $1, updated_at = $2
+	`, tableName)
+	_, err = tx.Exec(updateQuery, currentValue+ticks, lastTickAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error updating %s row.\n %s", tableName, err)
+	}
+	return nil
+}
+
+// readCounter reads the counter stored in tableName within the given transaction and locks its
+// row until the transaction ends. It returns nil if there is no row.
+func (s *SQLStore) readCounter(tx *sql.Tx, tableName string) (*SnapshotCounter, error) {
+	var counter SnapshotCounter
+	var resetedAt sql.NullString
+	query := fmt.Sprintf(`
+		SELECT
+			current_value, COALESCE(max_value, 0), updated_at, reseted_at
+		FROM %s
+		LIMIT 1
+		%s
+	`, tableName, s.dialect.forUpdate)
+
+	err := tx.QueryRow(query).Scan(&counter.CurrentValue, &counter.MaxValue, &counter.UpdatedAt, &resetedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
+	}
+	if resetedAt.Valid {
+		counter.ResetedAt = &resetedAt.String
+	}
+	return &counter, nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"server/utils"
+	"time"
+)
+
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+var ErrUndoWindowExpired = errors.New("undo window expired")
+
+// transitionState returns the state entered by a transition event.
+func transitionState(kind EventKind) State {
+	if kind == EventFine {
+		return Healthy
+	}
+	return Ill
+}
+
+// stateBefore returns the state left by a transition event.
+func stateBefore(kind EventKind) State {
+	if kind == EventFine {
+		return Ill
+	}
+	return Healthy
+}
+
+// undoableTransition checks that lastEvent, the latest event in the log, is a transition
+// recorded less than window before now, and returns its payload. Only the latest event can be
+// undone, so that nothing recorded after the transition gets lost.
+func undoableTransition(lastEvent *Event, window time.Duration, now time.Time) (TransitionPayload, error) {
+	var payload TransitionPayload
+	if lastEvent == nil || (lastEvent.Kind != EventOhno && lastEvent.Kind != EventFine) {
+		return payload, fmt.Errorf("%w: the latest event is not an ohno or fine", ErrNothingToUndo)
+	}
+
+	err := json.Unmarshal(lastEvent.Payload, &payload)
+	if err != nil {
+		return payload, fmt.Errorf("❌ Error unmarshaling event %d payload.\n %s", lastEvent.EventID, err)
+	}
+	if payload.Undo == nil {
+		return payload, fmt.Errorf("%w: event %d was recorded before undo was supported", ErrNothingToUndo, lastEvent.EventID)
+	}
+
+	createdAt, err := parseTimestamp(lastEvent.CreatedAt)
+	if err != nil {
+		return payload, fmt.Errorf("❌ Error parsing created_at timestamp.\n %s", err)
+	}
+	if now.Sub(createdAt) > window {
+		return payload, fmt.Errorf("%w: %s was recorded at %s, more than %s ago", ErrUndoWindowExpired, lastEvent.Kind, createdAt.Format(time.RFC3339), window)
+	}
+	return payload, nil
+}
+
+// UndoLastTransition reverts the latest ohno or fine transition recorded less than window ago.
+// The counters, the state and the historical table are restored to what they were before the
+// transition and an undo event is appended to the log. It returns the restored state.
+func (s *SQLStore) UndoLastTransition(ctx context.Context, window time.Duration) (State, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("❌ Error starting transaction.\n %s", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		} else if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	current, err := s.lockState(tx)
+	if err != nil {
+		return "", err
+	}
+
+	lastEventQuery := fmt.Sprintf(`
+		SELECT
+			event_id, kind, occurred_at, created_at, payload
+		FROM %s
+		ORDER BY event_id DESC
+		LIMIT 1
+	`, utils.TableInstance.Events)
+
+	rows, err := tx.Query(lastEventQuery)
+	if err != nil {
+		return "", fmt.Errorf("❌ Error querying %s table.\n %s", utils.TableInstance.Events, err)
+	}
+	events, err := scanEvents(rows)
+	rows.Close()
+	if err != nil {
+		return "", err
+	}
+
+	var lastEvent *Event
+	if len(events) > 0 {
+		lastEvent = &events[0]
+	}
+	payload, err := undoableTransition(lastEvent, window, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	t := transitions[transitionState(lastEvent.Kind)]
+	previous := stateBefore(lastEvent.Kind)
+
+	err = s.restoreCounter(tx, t.tableToReset, payload.Undo.ResetCounter)
+	if err != nil {
+		return "", err
+	}
+	if payload.MovedTicks > 0 {
+		err = s.restoreCounter(tx, t.tableToActivate, payload.Undo.ActivatedCounter)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	slog.InfoContext(ctx, "↩️ Undoing...", "kind", lastEvent.Kind, "from", current.State, "to", previous)
+	err = s.setState(tx, previous, payload.Undo.ChangedAt)
+	if err != nil {
+		return "", err
+	}
+
+	if payload.CounterID != "" {
+		deleteQuery := fmt.Sprintf(`DELETE FROM %s WHERE counter_id = $1`, t.historicalTable)
+		_, err = tx.Exec(deleteQuery, payload.CounterID)
+		if err != nil {
+			return "", fmt.Errorf("❌ Error deleting %s row.\n %s", t.historicalTable, err)
+		}
+	}
+
+	err = s.appendEvent(tx, EventUndo, UndoPayload{EventID: lastEvent.EventID})
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", fmt.Errorf("❌ Error committing transaction.\n %s", err)
+	}
+	return previous, nil
+}
+
+// restoreCounter overwrites the counter stored in tableName with counter within the given
+// transaction. A nil counter deletes the row.
+func (s *SQLStore) restoreCounter(tx *sql.Tx, tableName string, counter *SnapshotCounter) error {
+	_, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s`, tableName))
+	if err != nil {
+		return fmt.Errorf("❌ Error deleting %s rows.\n %s", tableName, err)
+	}
+	if counter == nil {
+		return nil
+	}
+
+	insertQuery := fmt.Sprintf(`
+		INSERT INTO %s (current_value, max_value, updated_at, reseted_at)
+		VALUES ($1, $2, $3, $4)
+	`, tableName)
+	_, err = tx.Exec(insertQuery, counter.CurrentValue, counter.MaxValue, counter.UpdatedAt, counter.ResetedAt)
+	if err != nil {
+		return fmt.Errorf("❌ Error inserting new %s row.\n %s", tableName, err)
+	}
+	return nil
+}
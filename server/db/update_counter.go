@@ -1,11 +1,11 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"server/utils"
-	"strings"
 	"time"
 )
 
@@ -17,19 +17,12 @@ type Counter struct {
 	IsLocked     bool
 }
 
-type UpdateCounterType struct {
-	CurrentValue *int
-	UpdatedAt    *string
-	ResetedAt    *sql.NullString
-	IsLocked     *bool
-}
-
-func upsertCounterData(tableName string) (bool, error) {
+func (s *SQLStore) upsertCounterData(ctx context.Context, tableName string) (isUpdated bool, err error) {
 	if tableName == "" {
 		return false, fmt.Errorf("❌ Error upserting counter data. Table name cannot be empty.")
 	}
 
-	tx, err := db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return false, fmt.Errorf("❌ Error starting transaction.\n %s", err)
 	}
@@ -43,7 +36,8 @@ func upsertCounterData(tableName string) (bool, error) {
 		} else {
 			err = tx.Commit()
 			if err != nil {
-				log.Printf("❌ Error committing transaction.\n %s", err)
+				isUpdated = false
+				err = fmt.Errorf("❌ Error committing transaction.\n %s", err)
 			}
 		}
 	}()
@@ -52,28 +46,33 @@ func upsertCounterData(tableName string) (bool, error) {
 
 	upsertCounterQuery := fmt.Sprintf(`
 		SELECT 
-			current_value, is_locked, updated_at, reseted_at 
+			current_value, updated_at, reseted_at 
 		FROM 
 			%s
 		LIMIT 1 
-		FOR UPDATE
-	`, tableName)
+		%s
+	`, tableName, s.dialect.forUpdate)
 
-	err = tx.QueryRow(upsertCounterQuery).Scan(&counter.CurrentValue, &counter.IsLocked, &counter.UpdatedAt, &counter.ResetedAt)
+	err = tx.QueryRow(upsertCounterQuery).Scan(&counter.CurrentValue, &counter.UpdatedAt, &counter.ResetedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Println("No rows found in counter table. Inserting new row.")
+			slog.InfoContext(ctx, "No rows found in counter table. Inserting new row.", "table", tableName)
 
 			insertCounterQuery := fmt.Sprintf(`
 				INSERT INTO %s (current_value, max_value, updated_at)
-				VALUES (1, 1, NOW())
-			`, tableName)
+				VALUES (1, 1, %s)
+			`, tableName, s.dialect.now)
 
 			_, err = tx.Exec(insertCounterQuery)
 
 			if err != nil {
 				return false, fmt.Errorf("❌ Error inserting new counter row.\n %s", err)
 			}
+
+			err = s.appendEvent(tx, EventTick, TickPayload{Table: tableName, Value: 1})
+			if err != nil {
+				return false, err
+			}
 			return true, nil
 
 		} else {
@@ -86,66 +85,50 @@ func upsertCounterData(tableName string) (bool, error) {
 			return false, fmt.Errorf("❌ Error parsing updated_at timestamp.\n %s", err)
 		}
 
-		if counter.ResetedAt.Valid {
-			lastReseted, err := time.Parse(time.RFC3339Nano, counter.ResetedAt.String)
-			if err != nil {
-				return false, fmt.Errorf("❌ Error parsing reseted_at timestamp.\n %s", err)
-			}
-
-			if lastReseted.After(lastUpdated) || lastReseted.Equal(lastUpdated) {
-				log.Println("Counter was reseted. lastReseted <= lastUpdated")
-				updateQuery := fmt.Sprintf(`
-					UPDATE %s 
-					SET 
-						current_value = 1, updated_at = NOW()
-				`, tableName)
-				_, err = tx.Exec(updateQuery)
-				if err != nil {
-					return false, fmt.Errorf("❌ Error updating counter row.\n %s", err)
-				}
-			}
-		}
-
-		updateIntervalInt, err := utils.GetEnvInt("UPDATE_INTERVAL_IN_HOURS")
-		if err != nil {
-			return false, fmt.Errorf("❌ Error getting UPDATE_INTERVAL_IN_HOURS environment variable.\n %s", err)
-		}
-
-		updateInterval := time.Duration(updateIntervalInt)
-
-		if time.Since(lastUpdated) < updateInterval*time.Hour {
-			log.Printf("🙅 %d hours have not passed since the last update. Counter not increased...", updateIntervalInt)
+		// A reset leaves the counter at 1 as of reseted_at, which is also its updated_at. The
+		// tick below counts from there, nothing is written until it happens.
+		if time.Since(lastUpdated) < s.updateInterval {
+			slog.InfoContext(ctx, "🙅 Not enough time has passed since the last update. Counter not increased...", "table", tableName, "update_interval", s.updateInterval)
 			return false, nil
 		}
 
 		updateQuery := fmt.Sprintf(`
 			UPDATE %s 
-			SET current_value = current_value + 1, updated_at = NOW()
-		`, tableName)
+			SET current_value = current_value + 1, updated_at = %s
+			RETURNING current_value
+		`, tableName, s.dialect.now)
 
-		_, err = tx.Exec(updateQuery)
+		var newValue int
+		err = tx.QueryRow(updateQuery).Scan(&newValue)
 
 		if err != nil {
 			return false, fmt.Errorf("❌ Error updating counter row.\n %s", err)
 		}
+
+		err = s.appendEvent(tx, EventTick, TickPayload{Table: tableName, Value: newValue})
+		if err != nil {
+			return false, err
+		}
 	}
 
-	return true, err
+	return true, nil
 }
 
 // TODO: Refactor this function to improve error handling and readability
-func SetCounter(value int) error {
-	tx, err := db.Begin()
+func (s *SQLStore) SetCounter(ctx context.Context, value int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("❌ Error starting transaction.\n %s", err)
 	}
 
 	var counter Counter
 
-	err = tx.QueryRow("SELECT current_value, updated_at, reseted_at FROM counter LIMIT 1 FOR UPDATE").Scan(&counter.CurrentValue, &counter.UpdatedAt, &counter.ResetedAt)
+	selectQuery := fmt.Sprintf("SELECT current_value, updated_at, reseted_at FROM counter LIMIT 1 %s", s.dialect.forUpdate)
+	err = tx.QueryRow(selectQuery).Scan(&counter.CurrentValue, &counter.UpdatedAt, &counter.ResetedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			_, err = tx.Exec("INSERT INTO counter (current_value, updated_at) VALUES (?, NOW())", value)
+			insertQuery := fmt.Sprintf("INSERT INTO counter (current_value, updated_at) VALUES ($1, %s)", s.dialect.now)
+			_, err = tx.Exec(insertQuery, value)
 			if err != nil {
 				tx.Rollback()
 				return fmt.Errorf("❌ Error inserting new counter row.\n %s", err)
@@ -157,154 +140,52 @@ func SetCounter(value int) error {
 		}
 
 	} else {
-		_, err = tx.Exec("UPDATE counter SET current_value = $1, updated_at = NOW()", value)
+		updateQuery := fmt.Sprintf("UPDATE counter SET current_value = $1, updated_at = %s", s.dialect.now)
+		_, err = tx.Exec(updateQuery, value)
 		if err != nil {
-			log.Printf("Error updating counter.\n %s", err)
+			slog.ErrorContext(ctx, "Error updating counter.", "error", err)
 			tx.Rollback()
 			// BUG Why this does not throw error when update counter fails?
 			return fmt.Errorf("❌ Error updating counter row.\n %s", err)
 		}
 	}
 
+	err = s.appendEvent(tx, EventManualSet, ManualSetPayload{Value: value})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("❌ Error committing transaction.\n %s", err)
 	}
-	log.Println("✅ Transaction committed successfully")
+	slog.InfoContext(ctx, "✅ Transaction committed successfully")
 	return nil
 }
 
-func UpdateCounter() bool {
-	isUpdated, err := upsertCounterData(utils.TableInstance.Counter)
-
+func (s *SQLStore) UpdateCounter(ctx context.Context) (bool, error) {
+	isUpdated, err := s.upsertCounterData(ctx, utils.TableInstance.Counter)
 	if err != nil {
-		log.Printf("❌ Error updating counter.\n %s", err)
+		return false, err
 	}
 
 	if !isUpdated {
-		log.Printf("❌ Counter not incremented. Conditions not met.")
+		slog.InfoContext(ctx, "❌ Counter not incremented. Conditions not met.")
 	}
 
-	return isUpdated
+	return isUpdated, nil
 }
 
-func UpdateOhnoCounter() bool {
-	isUpdated, err := upsertCounterData(utils.TableInstance.OhnoCounter)
-
+func (s *SQLStore) UpdateOhnoCounter(ctx context.Context) (bool, error) {
+	isUpdated, err := s.upsertCounterData(ctx, utils.TableInstance.OhnoCounter)
 	if err != nil {
-		log.Printf("❌ Error updating counter.\n %s", err)
+		return false, err
 	}
 
 	if !isUpdated {
-		log.Printf("❌ Counter not incremented. Conditions not met.")
-	}
-
-	return isUpdated
-}
-
-func updateCounter(tableName string, properties UpdateCounterType) (bool, error) {
-	if tableName == "" {
-		return false, fmt.Errorf("❌ Error updating counter data. Table name cannot be empty.")
-	}
-
-	tx, err := db.Begin()
-	if err != nil {
-		return false, fmt.Errorf("❌ Error starting transaction: %w", err)
-	}
-
-	defer func() {
-		if p := recover(); p != nil {
-			tx.Rollback()
-			panic(p)
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			err = tx.Commit()
-			if err != nil {
-				log.Printf("❌ Error committing transaction: %s", err)
-			}
-		}
-	}()
-
-	upsertCounterQuery := fmt.Sprintf(`
-		SELECT 
-			current_value, is_locked, updated_at, reseted_at 
-		FROM 
-			%s
-		LIMIT 1 
-		FOR UPDATE
-	`, tableName)
-
-	var counter Counter
-
-	err = tx.QueryRow(upsertCounterQuery).Scan(&counter.CurrentValue, &counter.IsLocked, &counter.UpdatedAt, &counter.ResetedAt)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("No rows found in %s table. Inserting new row.", tableName)
-
-			insertCounterQuery := fmt.Sprintf(`
-				INSERT INTO %s (current_value, updated_at, is_locked)
-				VALUES (1, NOW(), false)
-			`, tableName)
-
-			_, err = tx.Exec(insertCounterQuery)
-
-			if err != nil {
-				return false, fmt.Errorf("❌ Error inserting new counter row.\n %s", err)
-			}
-			return true, nil
-
-		} else {
-			return false, fmt.Errorf("❌ Error querying %s table.\n %s", tableName, err)
-		}
-	}
-
-	var setClauses []string
-	var args []interface{}
-	argIndex := 1
-
-	if properties.CurrentValue != nil {
-		setClauses = append(setClauses, fmt.Sprintf("current_value = $%d", argIndex))
-		args = append(args, *properties.CurrentValue)
-		argIndex++
-	}
-	if properties.UpdatedAt != nil {
-		setClauses = append(setClauses, fmt.Sprintf("updated_at = $%d", argIndex))
-		args = append(args, *properties.UpdatedAt)
-		argIndex++
-	}
-	if properties.ResetedAt != nil {
-		setClauses = append(setClauses, fmt.Sprintf("reseted_at = $%d", argIndex))
-		args = append(args, *properties.ResetedAt)
-		argIndex++
-	}
-	if properties.IsLocked != nil {
-		setClauses = append(setClauses, fmt.Sprintf("is_locked = $%d", argIndex))
-		args = append(args, *properties.IsLocked)
-		argIndex++
-	}
-
-	if len(setClauses) == 0 {
-		return false, fmt.Errorf("❌ No properties provided for update")
-	}
-
-	query := fmt.Sprintf("UPDATE %s SET %s", tableName, strings.Join(setClauses, ", "))
-
-	_, err = tx.Exec(query, args...)
-	if err != nil {
-		log.Printf("❌ Error updating table: %s: %s", tableName, err)
-		return false, err
+		slog.InfoContext(ctx, "❌ Counter not incremented. Conditions not met.")
 	}
 
-	return true, nil
-}
-
-func LockCounter(tableName string) (bool, error) {
-	isLocked := true
-	return updateCounter(tableName, UpdateCounterType{IsLocked: &isLocked})
-}
-
-func UnlockCounter(tableName string) (bool, error) {
-	isLocked := false
-	return updateCounter(tableName, UpdateCounterType{IsLocked: &isLocked})
+	return isUpdated, nil
 }
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"server/db"
+	"server/utils"
+	"strings"
+)
+
+// APIKeyHeader carries the API key of clients that do not send it as a bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyFromRequest returns the key sent as Authorization: Bearer <key> or as X-API-Key.
+func apiKeyFromRequest(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get(APIKeyHeader)
+}
+
+type apiKeyContextKey struct{}
+
+// authenticatedAPIKey returns the API key the request was authenticated with, if any.
+func authenticatedAPIKey(r *http.Request) (db.APIKey, bool) {
+	apiKey, ok := r.Context().Value(apiKeyContextKey{}).(db.APIKey)
+	return apiKey, ok
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	utils.EnableCors(&w, r)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="oh-no"`)
+	errResponse := ServerResponse{Message: message}
+	MarshalJson(&w, http.StatusUnauthorized, errResponse)
+}
+
+// Require returns a middleware running next only for requests carrying an unrevoked API key
+// whose role allows role. It answers 401 without a valid key and 403 when the role falls short.
+// Routes requiring the viewer role are public unless public_reads is turned off. CORS preflights
+// go through, browsers send them without credentials.
+func (h *Handlers) Require(role db.Role) func(next http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if role == db.RoleViewer && h.cfg.PublicReads {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "OPTIONS" {
+				next(w, r)
+				return
+			}
+
+			key := apiKeyFromRequest(r)
+			if key == "" {
+				slog.WarnContext(r.Context(), "🔒 Missing API key", "path", r.URL.Path)
+				unauthorized(w, r, "Missing API key, send it as a bearer token in the Authorization header")
+				return
+			}
+			apiKey, err := h.store.AuthenticateAPIKey(r.Context(), key)
+			if errors.Is(err, db.ErrUnknownAPIKey) {
+				slog.WarnContext(r.Context(), "🔒 Invalid API key", "path", r.URL.Path)
+				unauthorized(w, r, "Invalid API key")
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(r.Context(), "❌ Error authenticating API key.", "error", err)
+				utils.EnableCors(&w, r)
+				errResponse := ServerResponse{Message: "Error authenticating API key."}
+				MarshalJson(&w, http.StatusInternalServerError, errResponse)
+				return
+			}
+
+			if !apiKey.Role.Allows(role) {
+				slog.WarnContext(r.Context(), "🔒 Forbidden", "path", r.URL.Path, "api_key_id", apiKey.ID, "role", apiKey.Role, "required_role", role)
+				utils.EnableCors(&w, r)
+				errResponse := ServerResponse{Message: fmt.Sprintf("The %s role is not allowed to call %s, it requires %s.", apiKey.Role, r.URL.Path, role)}
+				MarshalJson(&w, http.StatusForbidden, errResponse)
+				return
+			}
+
+			slog.DebugContext(r.Context(), "🔑 Authenticated", "api_key_id", apiKey.ID, "api_key_name", apiKey.Name, "role", apiKey.Role)
+			next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, apiKey)))
+		}
+	}
+}
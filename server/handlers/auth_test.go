@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/db"
+	"testing"
+)
+
+// newTestHandlers returns handlers on top of an empty in-memory store.
+func newTestHandlers(publicReads bool) (*Handlers, *db.MemoryStore) {
+	store := db.NewMemoryStore()
+	cfg := &config.Config{
+		PublicReads:                     publicReads,
+		IdempotencyKeyTTLInHours:        24,
+		IdempotencyKeyInFlightInSeconds: 120,
+	}
+	return New(store, nil, cfg), store
+}
+
+// createTestAPIKey creates a key with the given role and returns it along with its row.
+func createTestAPIKey(t *testing.T, store db.CounterStore, name string, role db.Role) (string, db.APIKey) {
+	t.Helper()
+	key, err := db.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	apiKey, err := store.CreateAPIKey(context.Background(), name, role, key)
+	if err != nil {
+		t.Fatalf("failed to create key: %s", err)
+	}
+	return key, apiKey
+}
+
+// decodeResponse returns the message of the JSON body of response.
+func decodeResponse(t *testing.T, response *httptest.ResponseRecorder) string {
+	t.Helper()
+	if contentType := response.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("expected a JSON response, got Content-Type %q", contentType)
+	}
+	var body ServerResponse
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response %q: %s", response.Body.String(), err)
+	}
+	return body.Message
+}
+
+// echoAPIKey answers with the name of the API key the request was authenticated with.
+func echoAPIKey(w http.ResponseWriter, r *http.Request) {
+	message := "anonymous"
+	if apiKey, ok := authenticatedAPIKey(r); ok {
+		message = apiKey.Name
+	}
+	MarshalJson(&w, http.StatusOK, ServerResponse{Message: message})
+}
+
+func TestRequire(t *testing.T) {
+	h, store := newTestHandlers(false)
+	viewerKey, _ := createTestAPIKey(t, store, "viewer", db.RoleViewer)
+	recorderKey, _ := createTestAPIKey(t, store, "recorder", db.RoleRecorder)
+	revokedKey, revoked := createTestAPIKey(t, store, "revoked", db.RoleAdmin)
+	if err := store.RevokeAPIKey(context.Background(), revoked.ID); err != nil {
+		t.Fatalf("failed to revoke key: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		role          db.Role
+		header        string
+		value         string
+		expectedCode  int
+		expectedBody  string
+		authenticates bool
+	}{
+		{"missing key", db.RoleRecorder, "", "", http.StatusUnauthorized, "Missing API key, send it as a bearer token in the Authorization header", false},
+		{"invalid key", db.RoleRecorder, "Authorization", "Bearer " + recorderKey + "x", http.StatusUnauthorized, "Invalid API key", false},
+		{"revoked key", db.RoleRecorder, "Authorization", "Bearer " + revokedKey, http.StatusUnauthorized, "Invalid API key", false},
+		{"role too low", db.RoleRecorder, "Authorization", "Bearer " + viewerKey, http.StatusForbidden, "The viewer role is not allowed to call /ohno, it requires recorder.", false},
+		{"bearer token", db.RoleRecorder, "Authorization", "bearer " + recorderKey, http.StatusOK, "recorder", true},
+		{"X-API-Key header", db.RoleRecorder, APIKeyHeader, recorderKey, http.StatusOK, "recorder", true},
+		{"higher role", db.RoleViewer, "Authorization", "Bearer " + recorderKey, http.StatusOK, "recorder", true},
+		{"viewer route without public_reads", db.RoleViewer, "", "", http.StatusUnauthorized, "Missing API key, send it as a bearer token in the Authorization header", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/ohno", nil)
+			if tc.header != "" {
+				request.Header.Set(tc.header, tc.value)
+			}
+			response := httptest.NewRecorder()
+			h.Require(tc.role)(echoAPIKey)(response, request)
+
+			if response.Code != tc.expectedCode {
+				t.Errorf("expected status %d, got %d", tc.expectedCode, response.Code)
+			}
+			if message := decodeResponse(t, response); message != tc.expectedBody {
+				t.Errorf("expected message %q, got %q", tc.expectedBody, message)
+			}
+			challenge := response.Header().Get("WWW-Authenticate")
+			if (response.Code == http.StatusUnauthorized) != (challenge != "") {
+				t.Errorf("expected WWW-Authenticate only along with 401, got %q", challenge)
+			}
+		})
+	}
+}
+
+func TestRequirePublicReads(t *testing.T) {
+	h, _ := newTestHandlers(true)
+
+	// Viewer routes are public
+	response := httptest.NewRecorder()
+	h.Require(db.RoleViewer)(echoAPIKey)(response, httptest.NewRequest("GET", "/counter", nil))
+	if response.Code != http.StatusOK || decodeResponse(t, response) != "anonymous" {
+		t.Errorf("expected a public viewer route, got %d %q", response.Code, response.Body.String())
+	}
+
+	// Mutating routes are not
+	response = httptest.NewRecorder()
+	h.Require(db.RoleRecorder)(echoAPIKey)(response, httptest.NewRequest("POST", "/ohno", nil))
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+}
+
+func TestRequireCors(t *testing.T) {
+	h, _ := newTestHandlers(false)
+	origin := "http://localhost:3000"
+
+	// Preflights are sent without credentials and go through
+	preflight := httptest.NewRequest("OPTIONS", "/ohno", nil)
+	preflight.Header.Set("Origin", origin)
+	response := httptest.NewRecorder()
+	h.Require(db.RoleAdmin)(echoAPIKey)(response, preflight)
+	if response.Code != http.StatusOK {
+		t.Errorf("expected the preflight to go through, got %d", response.Code)
+	}
+
+	// Refusals carry the CORS headers, so that the UI can read them
+	for _, tc := range []struct {
+		name         string
+		value        string
+		expectedCode int
+	}{
+		{"missing key", "", http.StatusUnauthorized},
+		{"invalid key", "Bearer invalid", http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", "/ohno", nil)
+			request.Header.Set("Origin", origin)
+			if tc.value != "" {
+				request.Header.Set("Authorization", tc.value)
+			}
+			response := httptest.NewRecorder()
+			h.Require(db.RoleRecorder)(echoAPIKey)(response, request)
+			if response.Code != tc.expectedCode {
+				t.Errorf("expected status %d, got %d", tc.expectedCode, response.Code)
+			}
+			if allowed := response.Header().Get("Access-Control-Allow-Origin"); allowed != origin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", origin, allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"server/db"
+	"time"
+)
+
+func (h *Handlers) GetCalendar(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /calendar.ics request")
+
+	calendar, err := db.BuildCalendar(r.Context(), h.store, time.Now().UTC(), h.cfg.CalendarLocation())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error building calendar.", "error", err)
+		errResponse := ServerResponse{Message: "Error building calendar."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="oh-no.ics"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(calendar))
+}
@@ -1,23 +1,22 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
-	"server/coroutines"
 )
 
-func StartAutoUpdateCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received POST /start-incr request")
-	coroutines.RunBackgroundTask()
+func (h *Handlers) StartAutoUpdateCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received POST /start-incr request")
+	h.backgroundTask.RunBackgroundTask()
 	response := ServerResponse{Message: "Background task stared."}
 	MarshalJson(&w, http.StatusOK, response)
-	log.Println("🟢 Background task started")
+	slog.InfoContext(r.Context(), "🟢 Background task started")
 }
 
-func StopAutoUpdateCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received POST /stop_incr request")
+func (h *Handlers) StopAutoUpdateCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received POST /stop_incr request")
 	response := ServerResponse{Message: "Background task stopped."}
-	coroutines.StopBackgroundTask()
+	h.backgroundTask.StopBackgroundTask()
 	MarshalJson(&w, http.StatusOK, response)
-	log.Println("🔴 Background task stopped")
+	slog.InfoContext(r.Context(), "🔴 Background task stopped")
 }
@@ -2,77 +2,101 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"server/db"
 	"server/utils"
 )
 
-func GetCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /counter request\n")
+func (h *Handlers) GetCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /counter request")
+	utils.EnableCors(&w, r)
 
-	counter, err := db.GetCounter("counter")
+	counter, err := h.store.GetCounter(r.Context(), "counter")
 	if err != nil {
-		log.Fatalf("❌ Error retrieving counter data.\n %s", err)
+		slog.ErrorContext(r.Context(), "❌ Error retrieving counter data.", "error", err)
+		errResponse := ServerResponse{Message: "Error retrieving counter data."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
 	}
 
-	utils.EnableCors(&w, r)
 	MarshalJson(&w, http.StatusOK, counter)
 }
 
-func GetOhnoCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /ohno-counter request\n")
+func (h *Handlers) GetOhnoCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /ohno-counter request")
 
-	counter, err := db.GetCounter("ohno_counter")
+	counter, err := h.store.GetCounter(r.Context(), "ohno_counter")
 	if err != nil {
-		log.Fatalf("❌ Error retrieving ohno_counter data.\n %s", err)
+		slog.ErrorContext(r.Context(), "❌ Error retrieving ohno_counter data.", "error", err)
+		errResponse := ServerResponse{Message: "Error retrieving ohno_counter data."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
 	}
 
 	MarshalJson(&w, http.StatusOK, counter)
 }
 
-func getHistoricalCounterEntries(w http.ResponseWriter, tableName string) {
-	hCounters, err := db.GetHistoricalCounters(tableName)
+func (h *Handlers) getHistoricalCounterEntries(w http.ResponseWriter, r *http.Request, tableName string) {
+	query, err := parseHistoricalQuery(r)
 	if err != nil {
-		log.Fatalf("❌ Error retrieving %s data.\n %s", tableName, err)
+		slog.WarnContext(r.Context(), "🙅 Invalid query.", "table", tableName, "error", err)
+		errResponse := ServerResponse{Message: err.Error()}
+		MarshalJson(&w, http.StatusBadRequest, errResponse)
+		return
 	}
-	MarshalJson(&w, http.StatusOK, hCounters)
+
+	page, err := h.store.ListHistoricalCounters(r.Context(), tableName, query)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		slog.WarnContext(r.Context(), "🙅 Invalid cursor.", "table", tableName, "error", err)
+		errResponse := ServerResponse{Message: "Invalid cursor"}
+		MarshalJson(&w, http.StatusBadRequest, errResponse)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error retrieving data.", "table", tableName, "error", err)
+		errResponse := ServerResponse{Message: fmt.Sprintf("Error retrieving %s data.", tableName)}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, page)
 }
 
-func GetHistoricalCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received GET /historical/counter request\n")
-	getHistoricalCounterEntries(w, utils.TableInstance.HistoricalCounter)
+func (h *Handlers) GetHistoricalCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /historical/counter request")
+	h.getHistoricalCounterEntries(w, r, utils.TableInstance.HistoricalCounter)
 }
 
-func GetHistoricalOhnoCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received GET /historical/ohno-counter request\n")
-	getHistoricalCounterEntries(w, utils.TableInstance.HistoricalOhnoCounter)
+func (h *Handlers) GetHistoricalOhnoCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /historical/ohno-counter request")
+	h.getHistoricalCounterEntries(w, r, utils.TableInstance.HistoricalOhnoCounter)
 }
 
-func IncrementCounter(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /increment request")
+func (h *Handlers) IncrementCounter(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /increment request")
 	switch r.Method {
 	case "POST":
 
-		if !IsCounterLocked() && !IsOhnoCounterLocked() {
-			log.Printf("🤔 Both counters are unlocked. Something went wrong.")
-			errResponse := ServerResponse{Message: "Both counters are unlocked. Something went wrong."}
-			MarshalJson(&w, http.StatusInternalServerError, errResponse)
-			log.Println("⁉️ Both counters are unlocked. Something went wrong.")
-			return
-		}
-
-		if IsCounterLocked() && IsOhnoCounterLocked() {
-			log.Printf("🤔 Both counters are locked. Something went wrong.")
-			errResponse := ServerResponse{Message: "Both counters are locked. Something went wrong."}
-			log.Println("⁉️ Both counters are locked. Something went wrong.")
+		healthState, err := h.store.GetState(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error retrieving state.", "error", err)
+			errResponse := ServerResponse{Message: "Error retrieving state."}
 			MarshalJson(&w, http.StatusInternalServerError, errResponse)
 			return
 		}
 
-		if IsOhnoCounterLocked() {
-			log.Printf("😀 Ohno Counter is locked. Proceeding with incrementing counter. Another happy day.")
-			isUpdated := db.UpdateCounter()
+		switch healthState.State {
+		case db.Healthy:
+			slog.InfoContext(r.Context(), "😀 State is healthy. Proceeding with incrementing counter. Another happy day.")
+			isUpdated, err := h.store.UpdateCounter(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "❌ Error updating counter.", "error", err)
+				errResponse := ServerResponse{Message: "Error updating counter."}
+				MarshalJson(&w, http.StatusInternalServerError, errResponse)
+				return
+			}
 
 			if !isUpdated {
 				errResponse := ServerResponse{Message: "Counter not incremented. Conditions not met."}
@@ -82,12 +106,17 @@ func IncrementCounter(w http.ResponseWriter, r *http.Request) {
 
 			response := ServerResponse{Message: "Counter incremented successfully"}
 			MarshalJson(&w, http.StatusOK, response)
-			log.Println("🟢 Counter incremented successfully")
-		}
-
-		if IsCounterLocked() {
-			log.Printf("🤮 Counter is locked. Proceeding with incrementing ohno counter. Illness continues.")
-			isUpdated := db.UpdateOhnoCounter()
+			slog.InfoContext(r.Context(), "🟢 Counter incremented successfully")
+
+		case db.Ill:
+			slog.InfoContext(r.Context(), "🤮 State is ill. Proceeding with incrementing ohno counter. Illness continues.")
+			isUpdated, err := h.store.UpdateOhnoCounter(r.Context())
+			if err != nil {
+				slog.ErrorContext(r.Context(), "❌ Error updating ohno counter.", "error", err)
+				errResponse := ServerResponse{Message: "Error updating ohno counter."}
+				MarshalJson(&w, http.StatusInternalServerError, errResponse)
+				return
+			}
 
 			if !isUpdated {
 				errResponse := ServerResponse{Message: "Counter not incremented. Conditions not met."}
@@ -97,11 +126,11 @@ func IncrementCounter(w http.ResponseWriter, r *http.Request) {
 
 			response := ServerResponse{Message: "Ohno counter incremented successfully"}
 			MarshalJson(&w, http.StatusOK, response)
-			log.Println("🟢 Ohno counter incremented successfully")
+			slog.InfoContext(r.Context(), "🟢 Ohno counter incremented successfully")
 		}
 
 	default:
-		log.Printf("❌ Only POST method is allowed")
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
 		errResponse := ServerResponse{Message: "Only POST method is allowed"}
 		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
 		return
@@ -112,25 +141,31 @@ type ManualCouterIncrementRequest struct {
 	Value int `json:"value"`
 }
 
-func SetCounterValue(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /manual-increment request")
+func (h *Handlers) SetCounterValue(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /manual-increment request")
 
 	switch r.Method {
 	case "POST":
 		var body ManualCouterIncrementRequest
 		err := json.NewDecoder(r.Body).Decode(&body)
 		if err != nil {
-			log.Printf("❌ Error decoding request body.\n %s", err)
+			slog.WarnContext(r.Context(), "❌ Error decoding request body.", "error", err)
 			errResponse := ServerResponse{Message: "Error decoding request body"}
 			MarshalJson(&w, http.StatusBadRequest, errResponse)
 			return
 		}
-		db.SetCounter(body.Value)
+		err = h.store.SetCounter(r.Context(), body.Value)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error setting counter.", "error", err)
+			errResponse := ServerResponse{Message: "Error setting counter."}
+			MarshalJson(&w, http.StatusInternalServerError, errResponse)
+			return
+		}
 		response := ServerResponse{Message: "Counter incremented successfully"}
 		MarshalJson(&w, http.StatusOK, response)
-		log.Println("🟢 Counter incremented successfully")
+		slog.InfoContext(r.Context(), "🟢 Counter incremented successfully")
 	default:
-		log.Printf("❌ Only POST method is allowed")
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
 		errResponse := ServerResponse{Message: "Only POST method is allowed"}
 		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
 		return
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+func (h *Handlers) GetEvents(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /events request")
+
+	events, err := h.store.GetEvents(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error retrieving events.", "error", err)
+		errResponse := ServerResponse{Message: "Error retrieving events."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, events)
+}
+
+func (h *Handlers) RebuildCounters(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /rebuild request", "method", r.Method)
+	switch r.Method {
+	case "POST":
+		err := h.store.RebuildFromEvents(r.Context())
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error rebuilding counters.", "error", err)
+			errResponse := ServerResponse{Message: "Error rebuilding counters."}
+			MarshalJson(&w, http.StatusInternalServerError, errResponse)
+			return
+		}
+		response := ServerResponse{Message: "Counters rebuilt from events"}
+		MarshalJson(&w, http.StatusOK, response)
+		slog.InfoContext(r.Context(), "🟢 Counters rebuilt from events")
+	default:
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
+		errResponse := ServerResponse{Message: "Only POST method is allowed"}
+		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"server/db"
+	"strconv"
+	"time"
+)
+
+// exportFlushEvery is the number of records written between flushes of the response.
+const exportFlushEvery = 100
+
+// versionedExportRecord is an export record as written to NDJSON and JSON exports.
+type versionedExportRecord struct {
+	Version int `json:"version"`
+	db.ExportRecord
+}
+
+// exportWriter writes export records in one format. begin is called before the first record,
+// end after the last one.
+type exportWriter struct {
+	contentType string
+	extension   string
+	begin       func(w io.Writer) error
+	write       func(w io.Writer, record db.ExportRecord, first bool) error
+	end         func(w io.Writer) error
+}
+
+func newExportWriter(format string) (*exportWriter, bool) {
+	switch format {
+	case "csv":
+		var csvWriter *csv.Writer
+		return &exportWriter{
+			contentType: "text/csv",
+			extension:   "csv",
+			begin: func(w io.Writer) error {
+				csvWriter = csv.NewWriter(w)
+				return csvWriter.Write(db.ExportCSVHeader)
+			},
+			write: func(w io.Writer, record db.ExportRecord, first bool) error {
+				err := csvWriter.Write(record.CSVRow())
+				csvWriter.Flush()
+				if err != nil {
+					return err
+				}
+				return csvWriter.Error()
+			},
+			end: func(w io.Writer) error {
+				csvWriter.Flush()
+				return csvWriter.Error()
+			},
+		}, true
+	case "ndjson":
+		var encoder *json.Encoder
+		return &exportWriter{
+			contentType: "application/x-ndjson",
+			extension:   "ndjson",
+			begin: func(w io.Writer) error {
+				encoder = json.NewEncoder(w)
+				return nil
+			},
+			write: func(w io.Writer, record db.ExportRecord, first bool) error {
+				return encoder.Encode(versionedExportRecord{Version: db.ExportVersion, ExportRecord: record})
+			},
+			end: func(w io.Writer) error { return nil },
+		}, true
+	case "json", "":
+		return &exportWriter{
+			contentType: "application/json",
+			extension:   "json",
+			begin: func(w io.Writer) error {
+				_, err := fmt.Fprintf(w, `{"version":%d,"exported_at":%q,"records":[`, db.ExportVersion, time.Now().UTC().Format(time.RFC3339Nano))
+				return err
+			},
+			write: func(w io.Writer, record db.ExportRecord, first bool) error {
+				if !first {
+					if _, err := io.WriteString(w, ","); err != nil {
+						return err
+					}
+				}
+				data, err := json.Marshal(versionedExportRecord{Version: db.ExportVersion, ExportRecord: record})
+				if err != nil {
+					return err
+				}
+				_, err = w.Write(data)
+				return err
+			},
+			end: func(w io.Writer) error {
+				_, err := io.WriteString(w, "]}\n")
+				return err
+			},
+		}, true
+	}
+	return nil, false
+}
+
+// Export streams the counters and both historical tables, see the Export section of the
+// README for the layout.
+func (h *Handlers) Export(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /export request")
+
+	format := r.URL.Query().Get("format")
+	exporter, ok := newExportWriter(format)
+	if !ok {
+		slog.WarnContext(r.Context(), "🙅 Unknown export format", "format", format)
+		errResponse := ServerResponse{Message: "Invalid format: must be one of csv, ndjson, json"}
+		MarshalJson(&w, http.StatusBadRequest, errResponse)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	started := false
+	count := 0
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", exporter.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ohno-export-v%d.%s"`, db.ExportVersion, exporter.extension))
+		w.Header().Set("Export-Version", strconv.Itoa(db.ExportVersion))
+		w.WriteHeader(http.StatusOK)
+		return exporter.begin(w)
+	}
+
+	err := h.store.Export(r.Context(), func(record db.ExportRecord) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		err := exporter.write(w, record, count == 0)
+		if err != nil {
+			return err
+		}
+		count++
+		if flusher != nil && count%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error exporting data.", "error", err)
+		if !started {
+			errResponse := ServerResponse{Message: "Error exporting data."}
+			MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		}
+		// The status is already sent, the truncated body is all the client gets
+		return
+	}
+
+	err = exporter.end(w)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error exporting data.", "error", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "🟢 Exported records", "records", count)
+}
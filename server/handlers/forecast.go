@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"server/db"
+)
+
+func (h *Handlers) GetForecast(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /forecast request")
+
+	days, err := parseIntParam(r.URL.Query().Get("days"), "days")
+	if err == nil && days != nil && (*days < 1 || *days > db.MaxForecastDays) {
+		err = fmt.Errorf("Invalid days: must be between 1 and %d", db.MaxForecastDays)
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "🙅 Invalid forecast query.", "error", err)
+		errResponse := ServerResponse{Message: err.Error()}
+		MarshalJson(&w, http.StatusBadRequest, errResponse)
+		return
+	}
+	horizon := db.DefaultForecastDays
+	if days != nil {
+		horizon = *days
+	}
+
+	forecast, err := db.BuildForecast(r.Context(), h.store, horizon)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error computing forecast.", "error", err)
+		errResponse := ServerResponse{Message: "Error computing forecast."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, forecast)
+}
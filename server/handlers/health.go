@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"server/db"
+	"time"
+)
+
+// readinessTimeout bounds the checks of /readyz, so that a hung database fails the probe
+// instead of blocking it.
+const readinessTimeout = 5 * time.Second
+
+// Liveness is the body of /healthz.
+type Liveness struct {
+	Status        string  `json:"status"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// Healthz answers 200 as long as the process serves requests. It does not touch the database,
+// a database outage is reported by /readyz instead of getting the process restarted.
+func (h *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	MarshalJson(&w, http.StatusOK, Liveness{Status: "ok", UptimeSeconds: time.Since(h.startedAt).Seconds()})
+}
+
+// Readyz answers 200 when the database is reachable, the schema is up to date and the counters
+// are consistent, 503 otherwise. The body lists every check either way.
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	readiness := db.CheckReadiness(ctx, h.store)
+	if !readiness.Ready {
+		slog.WarnContext(r.Context(), "🙅 Not ready", "checks", readiness.Checks)
+		MarshalJson(&w, http.StatusServiceUnavailable, readiness)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, readiness)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"server/db"
+	"strconv"
+	"time"
+)
+
+// parseHistoricalQuery reads the filters and the page of the /historical/* endpoints from the
+// query string. from and to accept RFC 3339 timestamps or dates, limit defaults to
+// db.DefaultHistoricalLimit.
+func parseHistoricalQuery(r *http.Request) (db.HistoricalQuery, error) {
+	values := r.URL.Query()
+	query := db.HistoricalQuery{
+		Sort:   db.HistoricalSort(values.Get("sort")),
+		Cursor: values.Get("cursor"),
+	}
+
+	var err error
+	query.From, err = parseTimeParam(values.Get("from"), "from")
+	if err != nil {
+		return db.HistoricalQuery{}, err
+	}
+	query.To, err = parseTimeParam(values.Get("to"), "to")
+	if err != nil {
+		return db.HistoricalQuery{}, err
+	}
+	query.MinValue, err = parseIntParam(values.Get("min_value"), "min_value")
+	if err != nil {
+		return db.HistoricalQuery{}, err
+	}
+	query.MaxValue, err = parseIntParam(values.Get("max_value"), "max_value")
+	if err != nil {
+		return db.HistoricalQuery{}, err
+	}
+
+	limit, err := parseIntParam(values.Get("limit"), "limit")
+	if err != nil {
+		return db.HistoricalQuery{}, err
+	}
+	if limit != nil {
+		if *limit < 1 || *limit > db.MaxHistoricalLimit {
+			return db.HistoricalQuery{}, fmt.Errorf("Invalid limit: must be between 1 and %d", db.MaxHistoricalLimit)
+		}
+		query.Limit = *limit
+	}
+
+	if query.Sort != "" && !query.Sort.Valid() {
+		return db.HistoricalQuery{}, fmt.Errorf("Invalid sort: must be one of %s, %s, %s, %s", db.SortCreatedAtAsc, db.SortCreatedAtDesc, db.SortValueAsc, db.SortValueDesc)
+	}
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return db.HistoricalQuery{}, fmt.Errorf("Invalid from: must be before to")
+	}
+	if query.MinValue != nil && query.MaxValue != nil && *query.MinValue > *query.MaxValue {
+		return db.HistoricalQuery{}, fmt.Errorf("Invalid min_value: must not be greater than max_value")
+	}
+	return query, nil
+}
+
+func parseTimeParam(value string, name string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid %s: expected an RFC 3339 timestamp or a date, got %q", name, value)
+}
+
+func parseIntParam(value string, name string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: expected an integer, got %q", name, value)
+	}
+	return &n, nil
+}
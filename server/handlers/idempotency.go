@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"server/db"
+	"server/utils"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// responseRecorder passes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// idempotencyScope is the caller idempotency keys belong to, so that callers cannot replay each
+// other's responses.
+func idempotencyScope(r *http.Request) string {
+	apiKey, ok := authenticatedAPIKey(r)
+	if !ok {
+		return "anonymous"
+	}
+	return fmt.Sprintf("api_key:%d", apiKey.ID)
+}
+
+// hashRequest identifies a request by its method, path and body. The body is read and put back
+// for the handler.
+func hashRequest(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Idempotent makes POST requests sent with an Idempotency-Key header run next at most once per
+// key and caller. Repeated requests get the stored response replayed instead, reusing a key for
+// another request is refused. Server errors are not stored, so that retrying them runs next
+// again.
+func (h *Handlers) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != "POST" {
+			next(w, r)
+			return
+		}
+
+		utils.EnableCors(&w, r)
+		if len(key) > maxIdempotencyKeyLength {
+			slog.WarnContext(r.Context(), "❌ Idempotency-Key is too long")
+			errResponse := ServerResponse{Message: "Idempotency-Key must not be longer than 255 characters"}
+			MarshalJson(&w, http.StatusBadRequest, errResponse)
+			return
+		}
+
+		requestHash, err := hashRequest(r)
+		if err != nil {
+			slog.WarnContext(r.Context(), "❌ Error reading request body.", "error", err)
+			errResponse := ServerResponse{Message: "Error reading request body"}
+			MarshalJson(&w, http.StatusBadRequest, errResponse)
+			return
+		}
+
+		scope := idempotencyScope(r)
+		stored, err := h.store.ReserveIdempotencyKey(r.Context(), scope, key, requestHash, h.cfg.IdempotencyKeyTTL(), h.cfg.IdempotencyKeyInFlight())
+		if errors.Is(err, db.ErrIdempotencyKeyInFlight) {
+			slog.WarnContext(r.Context(), "🙅 Refusing concurrent request.", "error", err)
+			errResponse := ServerResponse{Message: "A request with this Idempotency-Key is still in progress"}
+			MarshalJson(&w, http.StatusConflict, errResponse)
+			return
+		}
+		if errors.Is(err, db.ErrIdempotencyKeyReused) {
+			slog.WarnContext(r.Context(), "🙅 Refusing reused idempotency key.", "error", err)
+			errResponse := ServerResponse{Message: "Idempotency-Key was already used for another request"}
+			MarshalJson(&w, http.StatusUnprocessableEntity, errResponse)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error reserving idempotency key.", "error", err)
+			errResponse := ServerResponse{Message: "Error reserving idempotency key."}
+			MarshalJson(&w, http.StatusInternalServerError, errResponse)
+			return
+		}
+
+		if stored != nil {
+			slog.InfoContext(r.Context(), "🔁 Replaying response", "idempotency_key", key)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write([]byte(stored.Body))
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next(recorder, r)
+
+		// The request ran even if the client went away meanwhile, its outcome has to be stored.
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.statusCode >= http.StatusInternalServerError {
+			err = h.store.ReleaseIdempotencyKey(ctx, scope, key)
+		} else {
+			err = h.store.CompleteIdempotencyKey(ctx, scope, key, db.IdempotentResponse{StatusCode: recorder.statusCode, Body: recorder.body.String()})
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error storing response.", "idempotency_key", key, "error", err)
+		}
+	}
+}
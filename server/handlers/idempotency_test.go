@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"server/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	h, _ := newTestHandlers(false)
+	calls := 0
+	handler := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		MarshalJson(&w, http.StatusOK, ServerResponse{Message: fmt.Sprintf("call %d", calls)})
+	})
+	send := func(key string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/ohno", strings.NewReader(body))
+		request.Header.Set("Idempotency-Key", key)
+		response := httptest.NewRecorder()
+		handler(response, request)
+		return response
+	}
+
+	response := send("key", `{}`)
+	if response.Code != http.StatusOK || decodeResponse(t, response) != "call 1" {
+		t.Fatalf("expected the first request to run, got %d %q", response.Code, response.Body.String())
+	}
+
+	// Repeating the request replays its response
+	response = send("key", `{}`)
+	if response.Code != http.StatusOK || decodeResponse(t, response) != "call 1" {
+		t.Errorf("expected the response of the first request, got %d %q", response.Code, response.Body.String())
+	}
+	if response.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("expected Idempotent-Replayed, got headers %v", response.Header())
+	}
+
+	// Reusing the key with another body is refused
+	response = send("key", `{"occurred_at":"2024-01-01T00:00:00Z"}`)
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, response.Code)
+	}
+	if message := decodeResponse(t, response); message != "Idempotency-Key was already used for another request" {
+		t.Errorf("unexpected message %q", message)
+	}
+
+	// Another key runs the handler again
+	response = send("other-key", `{}`)
+	if response.Code != http.StatusOK || decodeResponse(t, response) != "call 2" {
+		t.Errorf("expected another key to run the handler, got %d %q", response.Code, response.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("expected the handler to run twice, got %d", calls)
+	}
+}
+
+// newIdempotentRequest returns a POST to /ohno sent with key, along with its request hash.
+func newIdempotentRequest(t *testing.T, key string) (*http.Request, string) {
+	t.Helper()
+	request := httptest.NewRequest("POST", "/ohno", nil)
+	request.Header.Set("Idempotency-Key", key)
+	requestHash, err := hashRequest(request)
+	if err != nil {
+		t.Fatalf("failed to hash request: %s", err)
+	}
+	return request, requestHash
+}
+
+func TestIdempotentInFlight(t *testing.T) {
+	h, store := newTestHandlers(false)
+	handler := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the handler not to run")
+	})
+
+	// The same request is still running
+	request, requestHash := newIdempotentRequest(t, "key")
+	_, err := store.ReserveIdempotencyKey(context.Background(), "anonymous", "key", requestHash, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to reserve key: %s", err)
+	}
+
+	response := httptest.NewRecorder()
+	handler(response, request)
+	if response.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, response.Code)
+	}
+	if message := decodeResponse(t, response); message != "A request with this Idempotency-Key is still in progress" {
+		t.Errorf("unexpected message %q", message)
+	}
+}
+
+func TestIdempotentServerError(t *testing.T) {
+	h, _ := newTestHandlers(false)
+	calls := 0
+	handler := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		MarshalJson(&w, http.StatusInternalServerError, ServerResponse{Message: "Error"})
+	})
+
+	// Server errors are not stored, retrying runs the handler again
+	for range 2 {
+		request, _ := newIdempotentRequest(t, "key")
+		handler(httptest.NewRecorder(), request)
+	}
+	if calls != 2 {
+		t.Errorf("expected the handler to run twice, got %d", calls)
+	}
+}
+
+// cancelAwareStore fails to store idempotent responses once the context is canceled, as the
+// SQL stores do.
+type cancelAwareStore struct {
+	db.CounterStore
+}
+
+func (s cancelAwareStore) CompleteIdempotencyKey(ctx context.Context, scope string, key string, response db.IdempotentResponse) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.CounterStore.CompleteIdempotencyKey(ctx, scope, key, response)
+}
+
+func TestIdempotentCompletesAfterDisconnect(t *testing.T) {
+	h, store := newTestHandlers(false)
+	h.store = cancelAwareStore{store}
+	ctx, cancel := context.WithCancel(context.Background())
+	handler := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+		// The client goes away while the request runs
+		cancel()
+		MarshalJson(&w, http.StatusOK, ServerResponse{Message: "done"})
+	})
+
+	request, requestHash := newIdempotentRequest(t, "key")
+	handler(httptest.NewRecorder(), request.WithContext(ctx))
+
+	stored, err := store.ReserveIdempotencyKey(context.Background(), "anonymous", "key", requestHash, time.Hour, time.Hour)
+	if err != nil || stored == nil || stored.StatusCode != http.StatusOK {
+		t.Errorf("expected the response to be stored, got %+v, %v", stored, err)
+	}
+}
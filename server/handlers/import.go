@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"server/db"
+)
+
+// maxImportBytes bounds the size of the CSV accepted by /import.
+const maxImportBytes = 10 << 20
+
+// Import reads historical rows in the CSV export layout. With dry_run=true it only reports what
+// would be imported.
+func (h *Handlers) Import(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /import request", "method", r.Method)
+	switch r.Method {
+	case "POST":
+		dryRun := r.URL.Query().Get("dry_run") == "true"
+		report, err := db.ImportCSV(r.Context(), h.store, http.MaxBytesReader(w, r.Body, maxImportBytes), dryRun)
+		if errors.Is(err, db.ErrInvalidImport) && report == nil {
+			slog.WarnContext(r.Context(), "🙅 Refusing to import.", "error", err)
+			errResponse := ServerResponse{Message: err.Error()}
+			MarshalJson(&w, http.StatusBadRequest, errResponse)
+			return
+		}
+		if errors.Is(err, db.ErrInvalidImport) {
+			slog.WarnContext(r.Context(), "🙅 Refusing to import, some rows are invalid", "invalid_rows", len(report.Errors))
+			MarshalJson(&w, http.StatusUnprocessableEntity, report)
+			return
+		}
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			slog.WarnContext(r.Context(), "🙅 Refusing to import.", "error", err)
+			errResponse := ServerResponse{Message: "Import is too large"}
+			MarshalJson(&w, http.StatusRequestEntityTooLarge, errResponse)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error importing.", "error", err)
+			errResponse := ServerResponse{Message: "Error importing."}
+			MarshalJson(&w, http.StatusInternalServerError, errResponse)
+			return
+		}
+		MarshalJson(&w, http.StatusOK, report)
+		slog.InfoContext(r.Context(), "🟢 Imported", "inserted", report.Inserted, "dry_run", dryRun)
+
+	default:
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
+		errResponse := ServerResponse{Message: "Only POST method is allowed"}
+		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
+		return
+	}
+}
@@ -1,78 +1,138 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"server/config"
+	"server/coroutines"
 	"server/db"
+	"server/metrics"
 	"server/utils"
+	"time"
 )
 
 type ServerResponse struct {
 	Message string `json:"message"`
 }
 
+// Handlers serves the HTTP API on top of the injected store and background task.
+type Handlers struct {
+	store          db.CounterStore
+	backgroundTask *coroutines.BackgroundTask
+	cfg            *config.Config
+	startedAt      time.Time
+}
+
+func New(store db.CounterStore, backgroundTask *coroutines.BackgroundTask, cfg *config.Config) *Handlers {
+	return &Handlers{store: store, backgroundTask: backgroundTask, cfg: cfg, startedAt: time.Now()}
+}
+
 func RedirectToCounter(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/counter", http.StatusSeeOther)
 }
 
-func recordEvent(w http.ResponseWriter, r *http.Request, tableToResetAndLock string, tableToUnlock string, historicalTable string, serverResponseOkMessage string) {
+// RecordEventRequest is the optional body of /ohno and /fine. OccurredAt backdates the event,
+// it defaults to now.
+type RecordEventRequest struct {
+	OccurredAt *time.Time `json:"occurred_at"`
+}
+
+func (h *Handlers) recordEvent(w http.ResponseWriter, r *http.Request, state db.State, serverResponseOkMessage string) {
 	switch r.Method {
 	case "POST":
-
-		last_value, err := db.ResetCounter(tableToResetAndLock)
-		if err != nil {
-			log.Printf("❌ Error resetting %s.\n %s", tableToResetAndLock, err)
-			http.Error(w, fmt.Sprintf("Error resetting %s.", tableToResetAndLock), http.StatusInternalServerError)
+		var body RecordEventRequest
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil && err != io.EOF {
+			slog.WarnContext(r.Context(), "❌ Error decoding request body.", "error", err)
+			errResponse := ServerResponse{Message: "Error decoding request body"}
+			MarshalJson(&w, http.StatusBadRequest, errResponse)
 			return
 		}
+		var occurredAt time.Time
+		if body.OccurredAt != nil {
+			occurredAt = *body.OccurredAt
+		}
 
-		_, err = db.UnlockCounter(tableToUnlock)
-		log.Printf("🔓 Unlocking %s...", tableToUnlock)
-		if err != nil {
-			log.Printf("❌ Error unlocking %s .\n %s", tableToUnlock, err)
-			http.Error(w, fmt.Sprintf("Error unlocking %s.", tableToUnlock), http.StatusInternalServerError)
+		err = h.store.TransitionTo(r.Context(), state, occurredAt)
+		if errors.Is(err, db.ErrInvalidOccurredAt) {
+			slog.WarnContext(r.Context(), "🙅 Refusing to transition.", "state", state, "error", err)
+			errResponse := ServerResponse{Message: err.Error()}
+			MarshalJson(&w, http.StatusBadRequest, errResponse)
 			return
 		}
-
-		_, err = db.LockCounter(tableToResetAndLock)
-		log.Printf("🔒 Locking %s...", tableToResetAndLock)
-		if err != nil {
-			log.Printf("❌ Error locking %s .\n %s", tableToResetAndLock, err)
-			http.Error(w, fmt.Sprintf("Error locking %s.", tableToResetAndLock), http.StatusInternalServerError)
+		if errors.Is(err, db.ErrIllegalTransition) {
+			slog.WarnContext(r.Context(), "🙅 Refusing to transition.", "state", state, "error", err)
+			errResponse := ServerResponse{Message: fmt.Sprintf("Already %s.", state)}
+			MarshalJson(&w, http.StatusConflict, errResponse)
 			return
 		}
-
-		err = db.CreateHistoricalCounter(historicalTable, last_value)
 		if err != nil {
-			log.Printf("❌ Error creating %s.\n %s", historicalTable, err)
-			http.Error(w, fmt.Sprintf("Error creating %s.", historicalTable), http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "❌ Error transitioning.", "state", state, "error", err)
+			http.Error(w, fmt.Sprintf("Error transitioning to %s.", state), http.StatusInternalServerError)
 			return
 		}
 
+		metrics.Transitions.WithLabelValues(string(state)).Inc()
+
 		response := ServerResponse{Message: serverResponseOkMessage}
 		MarshalJson(&w, http.StatusOK, response)
-		log.Printf("🟢 %s", serverResponseOkMessage)
+		slog.InfoContext(r.Context(), "🟢 "+serverResponseOkMessage)
 
 	default:
-		log.Printf("❌ Only POST method is allowed")
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
 		errResponse := ServerResponse{Message: "Only POST method is allowed"}
 		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
 		return
 	}
 }
 
-func RecordOhNoEvent(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /ohno request of type %s", r.Method)
+func (h *Handlers) RecordOhNoEvent(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /ohno request", "method", r.Method)
 	serverResponseOkMessage := "Oh No! Event recorded"
 	utils.EnableCors(&w, r)
-	recordEvent(w, r, utils.TableInstance.Counter, utils.TableInstance.OhnoCounter, utils.TableInstance.HistoricalCounter, serverResponseOkMessage)
+	h.recordEvent(w, r, db.Ill, serverResponseOkMessage)
 
 }
 
-func RecordFineEvent(w http.ResponseWriter, r *http.Request) {
-	log.Printf("🔗 received /fine request of type %s", r.Method)
+func (h *Handlers) RecordFineEvent(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /fine request", "method", r.Method)
 	serverResponseOkMessage := "It's all good now! Event recorded"
 	utils.EnableCors(&w, r)
-	recordEvent(w, r, utils.TableInstance.OhnoCounter, utils.TableInstance.Counter, utils.TableInstance.HistoricalOhnoCounter, serverResponseOkMessage)
+	h.recordEvent(w, r, db.Healthy, serverResponseOkMessage)
+}
+
+func (h *Handlers) UndoLastEvent(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received /undo request", "method", r.Method)
+	utils.EnableCors(&w, r)
+
+	switch r.Method {
+	case "POST":
+		state, err := h.store.UndoLastTransition(r.Context(), h.cfg.UndoWindow())
+		if errors.Is(err, db.ErrNothingToUndo) || errors.Is(err, db.ErrUndoWindowExpired) {
+			slog.WarnContext(r.Context(), "🙅 Refusing to undo.", "error", err)
+			errResponse := ServerResponse{Message: err.Error()}
+			MarshalJson(&w, http.StatusConflict, errResponse)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "❌ Error undoing the last event.", "error", err)
+			http.Error(w, "Error undoing the last event.", http.StatusInternalServerError)
+			return
+		}
+
+		serverResponseOkMessage := fmt.Sprintf("Last event undone, %s again", state)
+		response := ServerResponse{Message: serverResponseOkMessage}
+		MarshalJson(&w, http.StatusOK, response)
+		slog.InfoContext(r.Context(), "🟢 "+serverResponseOkMessage)
+
+	default:
+		slog.WarnContext(r.Context(), "❌ Only POST method is allowed", "method", r.Method)
+		errResponse := ServerResponse{Message: "Only POST method is allowed"}
+		MarshalJson(&w, http.StatusMethodNotAllowed, errResponse)
+		return
+	}
 }
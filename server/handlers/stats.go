@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"server/db"
+	"time"
+)
+
+func (h *Handlers) GetStats(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /stats request")
+
+	stats, err := db.BuildStats(r.Context(), h.store, time.Now().UTC())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error computing stats.", "error", err)
+		errResponse := ServerResponse{Message: "Error computing stats."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, stats)
+}
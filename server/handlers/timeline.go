@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"server/db"
+	"time"
+)
+
+func (h *Handlers) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	slog.InfoContext(r.Context(), "🔗 received GET /timeline request")
+
+	timeline, err := db.BuildTimeline(r.Context(), h.store, time.Now().UTC())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "❌ Error building timeline.", "error", err)
+		errResponse := ServerResponse{Message: "Error building timeline."}
+		MarshalJson(&w, http.StatusInternalServerError, errResponse)
+		return
+	}
+	MarshalJson(&w, http.StatusOK, timeline)
+}
@@ -2,9 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"server/db"
 )
 
 func MarshalJson(w *http.ResponseWriter, statusCode int, data interface{}) {
@@ -12,26 +11,9 @@ func MarshalJson(w *http.ResponseWriter, statusCode int, data interface{}) {
 	(*w).WriteHeader(statusCode)
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		log.Printf("❌ Error marshaling data to JSON.\n %s", err)
+		slog.Error("❌ Error marshaling data to JSON.", "error", err)
 		http.Error(*w, "Error marshaling data to JSON", http.StatusInternalServerError)
 		return
 	}
 	(*w).Write(jsonData)
 }
-
-func IsCounterLocked() bool {
-	counter, err := db.GetCounter("counter")
-
-	if err != nil {
-		return false
-	}
-	return counter.IsLocked
-}
-
-func IsOhnoCounterLocked() bool {
-	counter, err := db.GetCounter("ohno_counter")
-	if err != nil {
-		return false
-	}
-	return counter.IsLocked
-}
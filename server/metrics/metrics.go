@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"server/db"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "ohno"
+
+// Registry holds every metric served on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Transitions counts the successful /ohno and /fine transitions by the state entered.
+	Transitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transitions_total",
+		Help:      "Number of transitions by the state entered.",
+	}, []string{"state"})
+
+	// BackgroundTicks counts the ticks of the background task by outcome: updated when the
+	// counter was incremented, skipped when the update interval had not passed yet and error
+	// when the store failed.
+	BackgroundTicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "background_task_ticks_total",
+		Help:      "Number of ticks of the background task by result.",
+	}, []string{"result"})
+
+	// StoreErrors counts the reads of the store that failed while collecting the counters and
+	// the state, their series are left out of the scrape.
+	StoreErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "store_errors_total",
+		Help:      "Number of failed reads of the store while collecting metrics.",
+	})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Transitions,
+		BackgroundTicks,
+		StoreErrors,
+		requestDuration,
+	)
+	// Series start at zero instead of appearing with the first increment
+	for _, state := range []db.State{db.Ill, db.Healthy} {
+		Transitions.WithLabelValues(string(state))
+	}
+	for _, outcome := range []string{TickUpdated, TickSkipped, TickError} {
+		BackgroundTicks.WithLabelValues(outcome)
+	}
+}
+
+// Outcomes of a tick of the background task.
+const (
+	TickUpdated = "updated"
+	TickSkipped = "skipped"
+	TickError   = "error"
+)
+
+// RecordTick counts a tick of the background task given the result of UpdateCounter.
+func RecordTick(isUpdated bool, err error) {
+	BackgroundTicks.WithLabelValues(tickOutcome(isUpdated, err)).Inc()
+}
+
+func tickOutcome(isUpdated bool, err error) string {
+	switch {
+	case err != nil:
+		return TickError
+	case isUpdated:
+		return TickUpdated
+	default:
+		return TickSkipped
+	}
+}
+
+// InstrumentRoute observes the latency of every request served by handler under route.
+func InstrumentRoute(route string, handler http.HandlerFunc) http.Handler {
+	observer := requestDuration.MustCurryWith(prometheus.Labels{"route": route})
+	return promhttp.InstrumentHandlerDuration(observer, handler)
+}
+
+// Handler serves the registry in the Prometheus text format. Metrics that fail to be collected
+// are left out and logged instead of failing the whole scrape.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{
+		ErrorLog:      slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
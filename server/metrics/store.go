@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"server/db"
+	"server/utils"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	counterValueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "counter", "value"),
+		"Current value of the counter.",
+		[]string{"counter"}, nil,
+	)
+	counterMaxValueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "counter", "max_value"),
+		"Highest value the counter ever reached.",
+		[]string{"counter"}, nil,
+	)
+	activeCounterDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "active_counter"),
+		"1 for the counter incremented in the current state, 0 for the other one.",
+		[]string{"counter"}, nil,
+	)
+	secondsSinceResetDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "counter", "seconds_since_reset"),
+		"Seconds since the counter was last reset, absent if it never was.",
+		[]string{"counter"}, nil,
+	)
+	secondsInStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "seconds_in_state"),
+		"Seconds since the current state was entered, absent before the first transition.",
+		[]string{"state"}, nil,
+	)
+)
+
+// collectTimeout bounds the reads of the store on a scrape, so that a hung database does not
+// hang the scrape as well.
+const collectTimeout = 5 * time.Second
+
+// storeCollector reads the counters and the state from the store on every scrape.
+type storeCollector struct {
+	store db.CounterStore
+}
+
+// RegisterStore exposes the counters and the state of the store.
+func RegisterStore(store db.CounterStore) {
+	Registry.MustRegister(&storeCollector{store: store})
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- counterValueDesc
+	ch <- counterMaxValueDesc
+	ch <- activeCounterDesc
+	ch <- secondsSinceResetDesc
+	ch <- secondsInStateDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	now := time.Now()
+
+	// fail counts a failed read, the series depending on it are left out
+	fail := func(message string, err error) {
+		StoreErrors.Inc()
+		slog.ErrorContext(ctx, message, "error", err)
+	}
+
+	healthState, err := c.store.GetState(ctx)
+	if err != nil {
+		fail("❌ Error reading state.", err)
+		return
+	}
+	if healthState.ChangedAt.Valid {
+		changedAt, err := time.Parse(time.RFC3339Nano, healthState.ChangedAt.String)
+		if err != nil {
+			fail("❌ Error parsing changed_at timestamp.", err)
+		} else {
+			ch <- prometheus.MustNewConstMetric(secondsInStateDesc, prometheus.GaugeValue, now.Sub(changedAt).Seconds(), string(healthState.State))
+		}
+	}
+
+	for _, tableName := range []string{utils.TableInstance.Counter, utils.TableInstance.OhnoCounter} {
+		counter, err := c.store.GetCounter(ctx, tableName)
+		if err != nil {
+			fail("❌ Error reading counter.", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(counterValueDesc, prometheus.GaugeValue, float64(counter.CurrentValue), tableName)
+		ch <- prometheus.MustNewConstMetric(counterMaxValueDesc, prometheus.GaugeValue, float64(counter.MaxValue), tableName)
+		ch <- prometheus.MustNewConstMetric(activeCounterDesc, prometheus.GaugeValue, boolToFloat(!counter.IsLocked), tableName)
+
+		if !counter.ResetedAt.Valid {
+			continue
+		}
+		resetedAt, err := time.Parse(time.RFC3339Nano, counter.ResetedAt.String)
+		if err != nil {
+			fail("❌ Error parsing reseted_at timestamp.", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(secondsSinceResetDesc, prometheus.GaugeValue, now.Sub(resetedAt).Seconds(), tableName)
+	}
+}
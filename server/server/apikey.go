@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"server/db"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const apiKeyUsage = "usage: server apikey [flags] -role viewer|recorder|admin create NAME | [flags] list | [flags] revoke ID"
+
+// runAPIKey handles the apikey subcommand and returns the process exit code.
+func runAPIKey(args []string) int {
+	flags := flag.NewFlagSet("apikey", flag.ContinueOnError)
+	role := flags.String("role", "", "role of the created key: viewer, recorder or admin")
+	cfg, exitCode := loadConfig(flags, args)
+	if cfg == nil {
+		return exitCode
+	}
+	args = flags.Args()
+	valid := len(args) == 2 && (args[0] == "create" || args[0] == "revoke") || len(args) == 1 && args[0] == "list"
+	if !valid || (args[0] == "create") != (*role != "") {
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+	if cfg.DBDriver == "memory" {
+		fmt.Fprintln(os.Stderr, "❌ The in-memory store keeps no API keys across runs")
+		return 2
+	}
+
+	store := db.NewStore(cfg)
+	defer store.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		return createAPIKey(ctx, store, args[1], db.Role(*role))
+	case "revoke":
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, apiKeyUsage)
+			return 2
+		}
+		err = store.RevokeAPIKey(ctx, id)
+		if errors.Is(err, db.ErrAPIKeyNotFound) {
+			fmt.Fprintf(os.Stderr, "❌ No API key with id %d\n", id)
+			return 1
+		}
+		if err != nil {
+			slog.Error("❌ Error revoking API key.", "error", err)
+			return 1
+		}
+		fmt.Printf("revoked: %d\n", id)
+		return 0
+	default:
+		return listAPIKeys(ctx, store)
+	}
+}
+
+func createAPIKey(ctx context.Context, store db.CounterStore, name string, role db.Role) int {
+	key, err := db.GenerateAPIKey()
+	if err != nil {
+		slog.Error("❌ Error creating API key.", "error", err)
+		return 1
+	}
+	apiKey, err := store.CreateAPIKey(ctx, name, role, key)
+	if errors.Is(err, db.ErrInvalidRole) {
+		fmt.Fprintf(os.Stderr, "❌ Invalid role %q, expected viewer, recorder or admin\n", role)
+		return 2
+	}
+	if err != nil {
+		slog.Error("❌ Error creating API key.", "error", err)
+		return 1
+	}
+	fmt.Printf("id: %d\nname: %s\nrole: %s\nkey: %s\n", apiKey.ID, apiKey.Name, apiKey.Role, key)
+	fmt.Fprintln(os.Stderr, "⚠️ Store the key now, only its hash is kept")
+	return 0
+}
+
+func listAPIKeys(ctx context.Context, store db.CounterStore) int {
+	apiKeys, err := store.ListAPIKeys(ctx)
+	if err != nil {
+		slog.Error("❌ Error listing API keys.", "error", err)
+		return 1
+	}
+	orDash := func(value *string) string {
+		if value == nil {
+			return "-"
+		}
+		return *value
+	}
+
+	output := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(output, strings.Join([]string{"ID", "NAME", "ROLE", "PREFIX", "CREATED", "LAST USED", "REVOKED"}, "\t"))
+	for _, apiKey := range apiKeys {
+		fmt.Fprintf(output, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", apiKey.ID, apiKey.Name, apiKey.Role, apiKey.Prefix, apiKey.CreatedAt, orDash(apiKey.LastUsedAt), orDash(apiKey.RevokedAt))
+	}
+	err = output.Flush()
+	if err != nil {
+		slog.Error("❌ Error listing API keys.", "error", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"server/db"
+)
+
+const importUsage = "usage: server import [-dry-run] [flags] FILE"
+
+// runImport handles the import subcommand and returns the process exit code.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without importing it")
+	cfg, exitCode := loadConfig(flags, args)
+	if cfg == nil {
+		return exitCode
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, importUsage)
+		return 2
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		slog.Error("❌ Error opening file.", "path", flags.Arg(0), "error", err)
+		return 1
+	}
+	defer file.Close()
+
+	store := db.NewStore(cfg)
+	defer store.Close()
+
+	report, err := db.ImportCSV(context.Background(), store, file, *dryRun)
+	if report != nil {
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+	}
+	if errors.Is(err, db.ErrInvalidImport) && report != nil {
+		slog.Warn("🙅 Nothing imported, some rows are invalid", "invalid_rows", len(report.Errors))
+		return 1
+	}
+	if err != nil {
+		slog.Error("❌ Error importing.", "error", err)
+		return 1
+	}
+	return 0
+}
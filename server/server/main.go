@@ -1,42 +1,148 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"server/config"
+	"server/coroutines"
 	"server/db"
 	"server/handlers"
+	"server/logging"
+	"server/metrics"
+	"server/utils"
 )
 
+// loadConfig loads and validates the configuration from the command line args parsed by fs and
+// sets up logging. A nil configuration comes with the exit code to return instead of running,
+// which is also the case of --print-config.
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, int) {
+	cfg, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, 0
+	}
+	if errors.Is(err, config.ErrInvalidFlags) {
+		// Already reported by the flag package along with the usage
+		return nil, 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+
+	if cfg.PrintConfig {
+		err = cfg.Print(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, 1
+		}
+	}
+	err = cfg.Validate()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+	if cfg.PrintConfig {
+		return nil, 0
+	}
+
+	err = logging.Setup(cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+	return cfg, 0
+}
+
+// createMemoryAPIKey creates and logs an API key for the in-memory store, which cannot be given
+// one with the apikey subcommand.
+func createMemoryAPIKey(store db.CounterStore) int {
+	key, err := db.GenerateAPIKey()
+	if err == nil {
+		_, err = store.CreateAPIKey(context.Background(), "memory", db.RoleAdmin, key)
+	}
+	if err != nil {
+		slog.Error("❌ Error creating API key.", "error", err)
+		return 1
+	}
+	slog.Warn("🔑 Use this admin API key with the in-memory store, it is gone once the server stops", "api_key", key)
+	return 0
+}
+
 func main() {
-	db.Connect()
-	http.HandleFunc("/ohno", handlers.RecordOhNoEvent)
-	http.HandleFunc("/", handlers.RedirectToCounter)
-	http.HandleFunc("/fine", handlers.RecordFineEvent)
-	http.HandleFunc("/historical/counter", handlers.GetHistoricalCounter)
-	http.HandleFunc("/historical/ohno-counter", handlers.GetHistoricalOhnoCounter)
-	http.HandleFunc("/counter", handlers.GetCounter)
-	http.HandleFunc("/ohno-counter", handlers.GetOhnoCounter)
-	http.HandleFunc("/start-incr", handlers.StartAutoUpdateCounter)
-	http.HandleFunc("/stop-incr", handlers.StopAutoUpdateCounter)
-	http.HandleFunc("/increment", handlers.IncrementCounter)
-	http.HandleFunc("/manual-increment", handlers.SetCounterValue)
-	port := os.Getenv("PORT")
-	host := os.Getenv("HOST")
-
-	addr := fmt.Sprintf("%s:%s", host, port)
-	log.Print("🏗️  Starting the server...")
-	log.Printf("🚀 Listening on %s\n", addr)
-
-	err := http.ListenAndServe(fmt.Sprintf(":%v", port), nil)
-
-	if errors.Is(err, http.ErrServerClosed) {
-		log.Printf("server closed\n")
-	} else if err != nil {
-		log.Fatalf("error starting server: %s\n", err)
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(runImport(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		os.Exit(runAPIKey(os.Args[2:]))
+	}
+
+	cfg, exitCode := loadConfig(flag.NewFlagSet("server", flag.ContinueOnError), os.Args[1:])
+	if cfg == nil {
+		os.Exit(exitCode)
+	}
+	utils.AllowOrigin(cfg.UIRootURL)
+
+	store := db.NewStore(cfg)
+	if cfg.DBDriver == "memory" {
+		exitCode = createMemoryAPIKey(store)
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
 	}
+	// Drift is worth a rebuild but does not keep the server from serving
+	if err := db.CheckReplay(context.Background(), store); err != nil {
+		slog.Warn("⚠️ State does not match the event log", "error", err)
+	}
+	backgroundTask := coroutines.NewBackgroundTask(store, cfg.CounterIncrementFrequency())
+	h := handlers.New(store, backgroundTask, cfg)
+	metrics.RegisterStore(store)
+
+	// handle registers the handler with its latency observed under the route
+	handle := func(route string, handler http.HandlerFunc) {
+		http.Handle(route, metrics.InstrumentRoute(route, handler))
+	}
+
+	// Every route but the redirect and the probes requires an API key with at least the given role, viewer
+	// routes being public unless public_reads is turned off
+	viewer := h.Require(db.RoleViewer)
+	recorder := h.Require(db.RoleRecorder)
+	admin := h.Require(db.RoleAdmin)
+
+	handle("/ohno", recorder(h.Idempotent(h.RecordOhNoEvent)))
+	handle("/", handlers.RedirectToCounter)
+	handle("/fine", recorder(h.Idempotent(h.RecordFineEvent)))
+	handle("/undo", recorder(h.UndoLastEvent))
+	handle("/historical/counter", viewer(h.GetHistoricalCounter))
+	handle("/historical/ohno-counter", viewer(h.GetHistoricalOhnoCounter))
+	handle("/timeline", viewer(h.GetTimeline))
+	handle("/stats", viewer(h.GetStats))
+	handle("/forecast", viewer(h.GetForecast))
+	handle("/export", viewer(h.Export))
+	handle("/import", admin(h.Import))
+	handle("/calendar.ics", viewer(h.GetCalendar))
+	handle("/counter", viewer(h.GetCounter))
+	handle("/ohno-counter", viewer(h.GetOhnoCounter))
+	handle("/start-incr", admin(h.StartAutoUpdateCounter))
+	handle("/stop-incr", admin(h.StopAutoUpdateCounter))
+	handle("/increment", admin(h.Idempotent(h.IncrementCounter)))
+	handle("/manual-increment", admin(h.Idempotent(h.SetCounterValue)))
+	handle("/events", viewer(h.GetEvents))
+	handle("/rebuild", admin(h.RebuildCounters))
+	handle("/metrics", viewer(metrics.Handler().ServeHTTP))
+	handle("/healthz", h.Healthz)
+	handle("/readyz", h.Readyz)
+
+	slog.Info("🏗️  Starting the server...")
+	slog.Info("🚀 Listening", "address", fmt.Sprintf("%s:%d", cfg.Host, cfg.Port))
 
+	server := newHTTPServer(cfg, handlers.RequestID(http.DefaultServeMux))
+	os.Exit(serve(cfg, server, backgroundTask, store))
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"server/db"
+)
+
+const migrateUsage = "usage: server migrate [flags] up|down|status"
+
+// runMigrate handles the migrate subcommand and returns the process exit code.
+func runMigrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	cfg, exitCode := loadConfig(flags, args)
+	if cfg == nil {
+		return exitCode
+	}
+	args = flags.Args()
+	if len(args) != 1 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	migrator, err := db.OpenMigrator(cfg)
+	if err != nil {
+		slog.Error("❌ Error migrating.", "error", err)
+		return 1
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		err = migrator.Down()
+	}
+	if err != nil {
+		slog.Error("❌ Error migrating.", "error", err)
+		return 1
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		slog.Error("❌ Error migrating.", "error", err)
+		return 1
+	}
+	fmt.Printf("version: %d\ndirty: %t\nlatest: %d\npending: %d\n", status.Version, status.Dirty, status.Latest, status.Latest-min(status.Version, status.Latest))
+	return 0
+}
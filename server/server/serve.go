@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"server/config"
+	"server/coroutines"
+	"server/db"
+	"syscall"
+)
+
+// newHTTPServer returns a server with the configured timeouts. The write timeout bounds whole
+// responses, /export included.
+func newHTTPServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.HTTPReadTimeout(),
+		ReadTimeout:       cfg.HTTPReadTimeout(),
+		WriteTimeout:      cfg.HTTPWriteTimeout(),
+		IdleTimeout:       cfg.HTTPIdleTimeout(),
+	}
+}
+
+// serve runs server until SIGINT or SIGTERM, then drains the requests in flight, stops the
+// background task and closes the store. It returns the process exit code.
+func serve(cfg *config.Config, server *http.Server, backgroundTask *coroutines.BackgroundTask, store db.CounterStore) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		slog.Error("❌ Error starting server.", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		// A second signal kills the process right away
+		stop()
+		slog.Info("🛑 Shutting down, draining requests in flight...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout())
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			slog.Error("❌ Error draining requests.", "error", err)
+			exitCode = 1
+		}
+		if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("❌ Error serving requests.", "error", err)
+			exitCode = 1
+		}
+	}
+
+	backgroundTask.Shutdown()
+	err := store.Close()
+	if err != nil {
+		slog.Error("❌ Error closing the store.", "error", err)
+		exitCode = 1
+	}
+	slog.Info("👋 Server stopped")
+	return exitCode
+}
@@ -5,6 +5,10 @@ type Table struct {
 	HistoricalCounter     string
 	OhnoCounter           string
 	HistoricalOhnoCounter string
+	HealthState           string
+	Events                string
+	IdempotencyKeys       string
+	APIKeys               string
 }
 
 func getTable() Table {
@@ -13,6 +17,10 @@ func getTable() Table {
 		HistoricalCounter:     "historical_counter",
 		OhnoCounter:           "ohno_counter",
 		HistoricalOhnoCounter: "historical_ohno_counter",
+		HealthState:           "health_state",
+		Events:                "events",
+		IdempotencyKeys:       "idempotency_keys",
+		APIKeys:               "api_keys",
 	}
 }
 
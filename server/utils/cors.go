@@ -2,14 +2,17 @@ package utils
 
 import (
 	"net/http"
-	"os"
 )
 
-var uiRootUrl = os.Getenv("UI_ROOT_URL")
-
 var allowedOrigins = []string{
 	"http://localhost:3000",
-	uiRootUrl,
+}
+
+// AllowOrigin lets the UI served from origin call the API, on top of the local one.
+func AllowOrigin(origin string) {
+	if origin != "" {
+		allowedOrigins = append(allowedOrigins, origin)
+	}
 }
 
 func isOriginAllowed(origin string) bool {
@@ -25,5 +28,7 @@ func EnableCors(w *http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
 	if isOriginAllowed(origin) {
 		(*w).Header().Set("Access-Control-Allow-Origin", origin)
+		(*w).Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Idempotency-Key, X-API-Key, X-Request-ID")
+		(*w).Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 	}
 }
@@ -1,29 +0,0 @@
-package utils
-
-import (
-	"errors"
-	"os"
-	"strconv"
-)
-
-var ErrEnvVarEmpty = errors.New("Getenv: environment variable empty")
-
-func GetEnvStr(key string) (string, error) {
-	v := os.Getenv(key)
-	if v == "" {
-		return v, ErrEnvVarEmpty
-	}
-	return v, nil
-}
-
-func GetEnvInt(key string) (int, error) {
-	s, err := GetEnvStr(key)
-	if err != nil {
-		return 0, err
-	}
-	v, err := strconv.Atoi(s)
-	if err != nil {
-		return 0, err
-	}
-	return v, nil
-}
@@ -4,6 +4,7 @@ import { DisplayCard } from "@/components/Card";
 import HealthStatus from "@/components/HealthStatus";
 import { ActionButton } from "@/components/ActionButton";
 import { recordEvent } from "@/utils/actions";
+import { authorizationHeader } from "@/utils/apiKey";
 import { ThemeToggle } from "@/components/ThemeToggle";
 
 /**
@@ -16,7 +17,10 @@ const fetchCounter = async (endpoint) => {
     const rootUrl = process.env.NEXT_PUBLIC_ROOT_API_URL;
     const url = `${rootUrl}/${endpoint}`;
 
-    const response = await fetch(url, { cache: "no-store" });
+    const response = await fetch(url, {
+      cache: "no-store",
+      headers: authorizationHeader(),
+    });
 
     if (!response.ok) {
       throw new Error("Failed to fetch data");
@@ -1,24 +1,27 @@
+"use server";
+
+import { authorizationHeader } from "@/utils/apiKey";
+
 /**
  * @typedef {Object } RecordEventApiResponse
  * @property {string} message - The message returned from the API.
  */
 
 /**
- * Records an event to the API.
+ * Records an event to the API. It runs as a server action, the API key it
+ * sends stays on the server.
+ * The request carries an Idempotency-Key so that a retry after a network
+ * failure replays the original response instead of recording the event twice.
  * @param {string} endpoint - The endpoint to record the event to.
  * @returns {Promise<void>} A promise that resolves when the event is successfully recorded.
  */
 export const recordEvent = async (eventType) => {
   const rootUrl = process.env.NEXT_PUBLIC_ROOT_API_URL;
   const url = `${rootUrl}/${eventType}`;
+  const idempotencyKey = crypto.randomUUID();
 
   try {
-    const response = await fetch(url, {
-      method: "POST",
-      headers: {
-        "Content-Type": "application/json",
-      },
-    });
+    const response = await postWithRetry(url, idempotencyKey);
 
     if (!response.ok) {
       throw new Error("Failed to record event");
@@ -36,3 +39,31 @@ export const recordEvent = async (eventType) => {
     throw new Error(error.message);
   }
 };
+
+const MAX_ATTEMPTS = 3;
+
+/**
+ * Posts to the API, retrying with the same Idempotency-Key when the request
+ * fails before a response is received.
+ * @param {string} url - The URL to post to.
+ * @param {string} idempotencyKey - The key identifying this event.
+ * @returns {Promise<Response>} The API response.
+ */
+const postWithRetry = async (url, idempotencyKey) => {
+  for (let attempt = 1; ; attempt++) {
+    try {
+      return await fetch(url, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+          "Idempotency-Key": idempotencyKey,
+          ...authorizationHeader(),
+        },
+      });
+    } catch (error) {
+      if (attempt >= MAX_ATTEMPTS) {
+        throw error;
+      }
+    }
+  }
+};
@@ -0,0 +1,11 @@
+/**
+ * Returns the Authorization header carrying the API key of the UI, none when
+ * OHNO_API_KEY is not set.
+ * The key is only read on the server: Next.js inlines NEXT_PUBLIC_ variables
+ * alone into browser bundles, so it never reaches the browser.
+ * @returns {Object<string, string>} The header, if any.
+ */
+export const authorizationHeader = () => {
+  const apiKey = process.env.OHNO_API_KEY;
+  return apiKey ? { Authorization: `Bearer ${apiKey}` } : {};
+};